@@ -57,6 +57,11 @@ Any delay in this routine can cause issues with latency
 func (p *sampleObserver) SwitchHandler(sw goflip.SwitchEvent) {
 }
 
+/*BallLaunched is called when the ball in play leaves the shooter lane*/
+func (p *sampleObserver) BallLaunched() {
+
+}
+
 /*BallDrained is called whenever a ball is drained on the playfield (Before PlayerEnd)*/
 func (p *sampleObserver) BallDrained() {
 
@@ -10,6 +10,7 @@ require (
 	github.com/sirupsen/logrus v1.8.1
 	go.bug.st/serial.v1 v0.0.0-20191202182710-24a6610f0541
 	golang.org/x/net v0.7.0 // indirect
+	gopkg.in/yaml.v2 v2.4.0
 	periph.io/x/conn/v3 v3.7.0
 	periph.io/x/host/v3 v3.8.2
 )
@@ -3,10 +3,12 @@ These are the methods for connecting to all of the supported arduino controllers
 SwitchMatrix
 Solenoid Driver Unit (SDU)
 Lamp Driver Unit (LDU)
+LED controller (optional, for RGB lamps)
 */
 package goflip
 
 import (
+	"errors"
 	"io"
 	"os"
 	"strings"
@@ -22,8 +24,11 @@ type arduino struct {
 	port        string
 	conn        io.ReadWriteCloser
 	consoleMode bool
+	extended    bool //the firmware takes extended messages, see SendShortMessage
 }
 
+const sduEscape = 0xff //starts an extended message, see SendShortMessage
+
 type swarduino struct {
 	arduino
 }
@@ -32,6 +37,7 @@ type arduinos struct {
 	switchMatrix swarduino
 	ldu          arduino
 	sdu          arduino
+	led          arduino
 	ports        []string
 }
 
@@ -125,6 +131,11 @@ func (a *arduinos) Connect() bool {
 			a.sdu.conn = s
 			a.sdu.consoleMode = false
 			log.Debugf("SDU Arduino connected at %s\n", port)
+		case 'd':
+			a.led.port = port
+			a.led.conn = s
+			a.led.consoleMode = false
+			log.Debugf("LED controller Arduino connected at %s\n", port)
 		}
 	}
 
@@ -152,6 +163,10 @@ func (a *arduinos) Disconnect() {
 	if !a.sdu.consoleMode && a.sdu.conn != nil {
 		_ = a.sdu.conn.Close()
 	}
+
+	if !a.led.consoleMode && a.led.conn != nil {
+		_ = a.led.conn.Close()
+	}
 }
 
 func (ard *swarduino) ReadSwitch() []SwitchEvent {
@@ -216,7 +231,49 @@ func (a *arduino) SendMessage(d deviceMessage) error {
 	return err
 }
 
-// Short Message format is 1 byte long. Top 5 bits is the ID, bottom 3 bits are the value
+// SendColorMessage sets a single LED on the LED string. Format is 1, LED (2 bytes, MSB first), red, green, blue
+func (a *arduino) SendColorMessage(led int, c Color) error {
+	tosend := []byte{1, byte(led >> 8), byte(led), c.R, c.G, c.B}
+
+	if a.consoleMode {
+		log.Printf("arduino write [%v]:%v", a.port, tosend)
+		return nil
+	}
+	if a.conn == nil {
+		return errors.New("not connected")
+	}
+
+	_, err := a.conn.Write(tosend)
+	return err
+}
+
+// SendRaw sends the bytes as they are, for messages that don't fit the other formats
+func (a *arduino) SendRaw(b []byte) error {
+	if a.consoleMode {
+		log.Printf("arduino write [%v]:%v", a.port, b)
+		return nil
+	}
+	if a.conn == nil {
+		return errors.New("not connected")
+	}
+
+	_, err := a.conn.Write(b)
+	return err
+}
+
+// SendExtended sends a message that doesn't fit in a short message (opcode first), framed as
+// sduEscape, the length of msg, then msg. Only for firmware that takes extended messages
+func (a *arduino) SendExtended(msg []byte) error {
+	return a.SendRaw(append([]byte{sduEscape, byte(len(msg))}, msg...))
+}
+
+// Short Message format is 1 byte long. Top 5 bits is the ID, bottom 3 bits are the value.
+//
+// Every byte is a valid short message, so extended messages (SendExtended) start with
+// sduEscape, which is also the short message for ID 31 value 7. When the firmware takes
+// extended messages (a.extended), that one short message is sent as sduEscape followed by
+// a length of 0, so the firmware can tell the two apart. Firmware that doesn't take
+// extended messages is never sent one, and gets every short message as it is.
 func (a *arduino) SendShortMessage(d deviceMessage, cmdSize int) error {
 	b := make([]byte, 1)
 
@@ -233,6 +290,10 @@ func (a *arduino) SendShortMessage(d deviceMessage, cmdSize int) error {
 
 	}
 
+	if a.extended && b[0] == sduEscape {
+		b = append(b, 0)
+	}
+
 	//	log.Debugf("Sending short message for %d:%d to %s", d.id, d.value, a.port)
 	_, err := a.conn.Write(b)
 
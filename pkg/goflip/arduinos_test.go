@@ -0,0 +1,51 @@
+package goflip
+
+import (
+	"bytes"
+	"testing"
+)
+
+type bufferConn struct {
+	bytes.Buffer
+}
+
+func (c *bufferConn) Close() error { return nil }
+
+func TestSendShortMessageEscape(t *testing.T) {
+	tests := []struct {
+		name     string
+		extended bool
+		msg      deviceMessage
+		want     []byte
+	}{
+		{"plain", false, deviceMessage{id: 3, value: 2}, []byte{3<<3 | 2}},
+		{"plain extended", true, deviceMessage{id: 3, value: 2}, []byte{3<<3 | 2}},
+		{"coil 31 on", false, deviceMessage{id: 31, value: sduHoldOn}, []byte{sduEscape}},
+		{"coil 31 on extended", true, deviceMessage{id: 31, value: sduHoldOn}, []byte{sduEscape, 0}},
+		{"coil 31 off extended", true, deviceMessage{id: 31, value: Off}, []byte{31 << 3}},
+	}
+
+	for _, tt := range tests {
+		conn := &bufferConn{}
+		a := arduino{conn: conn, extended: tt.extended}
+		if err := a.SendShortMessage(tt.msg, 3); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if !bytes.Equal(conn.Bytes(), tt.want) {
+			t.Errorf("%s: sent %v, want %v", tt.name, conn.Bytes(), tt.want)
+		}
+	}
+}
+
+func TestSendExtended(t *testing.T) {
+	conn := &bufferConn{}
+	a := arduino{conn: conn, extended: true}
+	if err := a.SendExtended([]byte{sduCoilHold, 4, 30}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []byte{sduEscape, 3, sduCoilHold, 4, 30}
+	if !bytes.Equal(conn.Bytes(), want) {
+		t.Errorf("sent %v, want %v", conn.Bytes(), want)
+	}
+}
@@ -0,0 +1,205 @@
+package goflip
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+attract runs the attract mode whenever there is no game in progress. The displays
+flash between the scores of the last game and blank, the AttractConfig.Lamps are
+chased (or the LampShow is played), and the Callouts are played every CalloutInterval. Attract mode is started
+when the machine goes back to the Attract state, and can be started after Init with
+StartAttract. Pressing any of the ExitSwitches (start, coin) stops attract mode; it
+starts again after RestartDelay if a game was not started.
+*/
+
+const (
+	attractScoresTimer  = "attract:scores"
+	attractLampsTimer   = "attract:lamps"
+	attractCalloutTimer = "attract:callout"
+	attractRestartTimer = "attract:restart"
+)
+
+// AttractConfig holds the settings for attract mode
+type AttractConfig struct {
+	Enabled         bool
+	ScoreCycle      time.Duration //time the last game scores are shown before blanking
+	Lamps           []int         //lamps chased during attract mode
+	LampShow        string        //lamp show played (looped) during attract mode instead of the chase
+	LampStep        time.Duration
+	Callouts        []byte        //sounds played in turn during attract mode
+	CalloutInterval time.Duration //0 for no callouts
+	ExitSwitches    []int         //switches that stop attract mode (start button, coin switches)
+	RestartDelay    time.Duration //time after an exit switch before attract mode starts again
+}
+
+type attractState struct {
+	mu         sync.Mutex
+	running    bool
+	showScores bool
+	lampStep   int
+	callout    int
+	savedLamps map[int]int
+}
+
+var attract attractState
+
+// StartAttract starts attract mode, if it is enabled and there is no game in progress
+func StartAttract() {
+	g := GetMachine()
+	cfg := g.AttractConfig
+
+	if !cfg.Enabled || GetState() != StateAttract || IsEnteringInitials() {
+		return
+	}
+
+	attract.mu.Lock()
+	if attract.running {
+		attract.mu.Unlock()
+		return
+	}
+	attract.running = true
+	attract.showScores = true
+	attract.lampStep = 0
+	attract.savedLamps = make(map[int]int, len(cfg.Lamps))
+	for _, l := range cfg.Lamps {
+		attract.savedLamps[l] = baseLampState(l)
+	}
+	attract.mu.Unlock()
+
+	log.Debugln("attract: starting")
+
+	showCredits()
+	showLastScores(true)
+
+	if cfg.ScoreCycle > 0 {
+		Every(attractScoresTimer, ScopeMachine, cfg.ScoreCycle, attractCycleScores)
+	}
+	if cfg.LampShow != "" {
+		if err := PlayLampShow(cfg.LampShow, LampShowOptions{Loops: -1}); err != nil {
+			log.Errorf("attract: %v", err)
+		}
+	} else if cfg.LampStep > 0 && len(cfg.Lamps) > 0 {
+		Every(attractLampsTimer, ScopeMachine, cfg.LampStep, attractLampStep)
+	}
+	if cfg.CalloutInterval > 0 && len(cfg.Callouts) > 0 {
+		Every(attractCalloutTimer, ScopeMachine, cfg.CalloutInterval, attractCallout)
+	}
+}
+
+// StopAttract stops attract mode, putting the lamps back the way they were
+func StopAttract() {
+	CancelTimer(attractRestartTimer)
+
+	attract.mu.Lock()
+	if !attract.running {
+		attract.mu.Unlock()
+		return
+	}
+	attract.running = false
+	saved := attract.savedLamps
+	attract.mu.Unlock()
+
+	log.Debugln("attract: stopping")
+
+	CancelTimer(attractScoresTimer)
+	CancelTimer(attractLampsTimer)
+	CancelTimer(attractCalloutTimer)
+	if cfg := GetMachine().AttractConfig; cfg.LampShow != "" {
+		StopLampShow(cfg.LampShow)
+	}
+
+	for l, state := range saved {
+		SetLampState(l, state)
+	}
+
+	showLastScores(true)
+	showCredits()
+}
+
+// IsAttractRunning returns true if attract mode is running
+func IsAttractRunning() bool {
+	attract.mu.Lock()
+	defer attract.mu.Unlock()
+	return attract.running
+}
+
+// LastGameScores returns the scores of the last completed game
+func LastGameScores() []int64 {
+	g := GetMachine()
+	return append([]int64(nil), g.lastScores...)
+}
+
+// attractSwitch is called for every switch event to stop attract mode on an exit switch
+func attractSwitch(sw SwitchEvent) {
+	g := GetMachine()
+	cfg := g.AttractConfig
+
+	if !sw.Pressed || !containsInt(cfg.ExitSwitches, sw.SwitchID) || !IsAttractRunning() {
+		return
+	}
+
+	StopAttract()
+
+	if cfg.RestartDelay > 0 {
+		After(attractRestartTimer, ScopeMachine, cfg.RestartDelay, StartAttract)
+	}
+}
+
+// recordLastScores is called at GameOver to keep the scores for attract mode
+func recordLastScores() {
+	g := GetMachine()
+	g.lastScores = make([]int64, g.NumOfPlayers)
+	for p := range g.lastScores {
+		g.lastScores[p] = PlayerScore(p + 1)
+	}
+}
+
+func showLastScores(show bool) {
+	g := GetMachine()
+
+	for d := 1; d <= maxScoreDisplays; d++ {
+		if show && d <= len(g.lastScores) {
+			SetDisplay(d, g.lastScores[d-1])
+		} else {
+			SetDisplay(d, blankScore)
+		}
+	}
+}
+
+func attractCycleScores() {
+	attract.mu.Lock()
+	attract.showScores = !attract.showScores
+	show := attract.showScores
+	attract.mu.Unlock()
+
+	showLastScores(show)
+}
+
+func attractLampStep() {
+	g := GetMachine()
+	lamps := g.AttractConfig.Lamps
+
+	attract.mu.Lock()
+	step := attract.lampStep
+	attract.lampStep = (step + 1) % len(lamps)
+	attract.mu.Unlock()
+
+	LampOff(lamps[(step+len(lamps)-1)%len(lamps)])
+	LampOn(lamps[step])
+}
+
+func attractCallout() {
+	g := GetMachine()
+	callouts := g.AttractConfig.Callouts
+
+	attract.mu.Lock()
+	callout := attract.callout
+	attract.callout = (callout + 1) % len(callouts)
+	attract.mu.Unlock()
+
+	PlaySound(callouts[callout])
+}
@@ -0,0 +1,249 @@
+package goflip
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+autofire fires a coil straight from a switch (slingshots, pop bumpers, kickers) without
+going through the Observers. Rules are registered at Init, and are only live in the
+machine states listed in the rule (ball starting and ball in play by default), so they
+go dead on a tilt and at game over.
+
+Switch events closer together than Debounce are ignored, and the coil is not fired
+again until Recycle has passed. The Observers still get the switch event for scoring.
+
+If the SDU firmware runs autofire rules itself (GoFlip.SDUAutofire), the rules are
+sent to the SDU when registered (or at Init, for rules registered before it) and turned
+on and off as the machine state changes. The SDU takes the debounce in milliseconds and
+the recycle in 10ms steps, a byte each, so they can be at most 255ms and 2.55s.
+Otherwise the rule is run on the switch read loop, before the switch goes anywhere else.
+*/
+
+const (
+	maxAutofireDebounce = 255 * time.Millisecond
+	maxAutofireRecycle  = 2550 * time.Millisecond
+	sduAutofireRule     = 0xfe //SDU extended message: rule, switch, coil, debounce (ms), recycle (10ms)
+	sduAutofireEnable   = 0xfd //SDU extended message: enable, switch, 0 or 1
+)
+
+// AutofireRule fires a coil when a switch is pressed
+type AutofireRule struct {
+	Name     string
+	Switch   int
+	Coil     int
+	Debounce time.Duration  //switch presses sooner than this after the last are ignored
+	Recycle  time.Duration  //least time between fires of the coil
+	States   []MachineState //states the rule is live in. Empty for ball starting and ball in play
+
+	mu        sync.Mutex
+	disabled  bool //turned off by DisableAutofire
+	live      bool //last enable state sent to the SDU
+	lastPress time.Time
+	lastFire  time.Time
+	fires     int
+}
+
+var (
+	autofireRules   []*AutofireRule
+	autofireRulesMu sync.Mutex
+)
+
+var defaultAutofireStates = []MachineState{StateBallStarting, StateBallInPlay}
+
+// RegisterAutofire adds an autofire rule. With SDUAutofire it is also sent to the SDU, once Init has started
+// talking to it
+func RegisterAutofire(r *AutofireRule) error {
+	if r.Debounce < 0 || r.Debounce > maxAutofireDebounce {
+		return fmt.Errorf("autofire rule %s: debounce of %v is more than %v", r.Name, r.Debounce, maxAutofireDebounce)
+	}
+	if r.Recycle < 0 || r.Recycle > maxAutofireRecycle {
+		return fmt.Errorf("autofire rule %s: recycle of %v is more than %v", r.Name, r.Recycle, maxAutofireRecycle)
+	}
+
+	autofireRulesMu.Lock()
+	autofireRules = append(autofireRules, r)
+	autofireRulesMu.Unlock()
+
+	if GetMachine().SDUAutofire && solenoidsStarted() {
+		sendAutofireRule(r)
+		r.sendEnable(r.isLive(GetState()))
+	}
+	return nil
+}
+
+// sendAutofireRules is called at Init, once the SolenoidSubscriber is running, to send the rules registered before it
+func sendAutofireRules() {
+	if !GetMachine().SDUAutofire {
+		return
+	}
+
+	autofireRulesMu.Lock()
+	rules := append([]*AutofireRule(nil), autofireRules...)
+	autofireRulesMu.Unlock()
+
+	state := GetState()
+	for _, r := range rules {
+		sendAutofireRule(r)
+		r.sendEnable(r.isLive(state))
+	}
+}
+
+// GetAutofire returns the registered rule with the name passed in, nil if there isn't one
+func GetAutofire(name string) *AutofireRule {
+	autofireRulesMu.Lock()
+	defer autofireRulesMu.Unlock()
+
+	for _, r := range autofireRules {
+		if r.Name == name {
+			return r
+		}
+	}
+	return nil
+}
+
+// EnableAutofire turns the named rule back on, after DisableAutofire
+func EnableAutofire(name string) error {
+	return setAutofireDisabled(name, false)
+}
+
+// DisableAutofire turns the named rule off whatever the machine state is
+func DisableAutofire(name string) error {
+	return setAutofireDisabled(name, true)
+}
+
+// Fires returns the number of times the rule has fired its coil
+func (r *AutofireRule) Fires() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.fires
+}
+
+func setAutofireDisabled(name string, disabled bool) error {
+	r := GetAutofire(name)
+	if r == nil {
+		return fmt.Errorf("unknown autofire rule %s", name)
+	}
+
+	r.mu.Lock()
+	r.disabled = disabled
+	r.mu.Unlock()
+
+	if GetMachine().SDUAutofire {
+		r.sendEnable(r.isLive(GetState()))
+	}
+	return nil
+}
+
+// isLive returns true if the rule should fire in the state
+func (r *AutofireRule) isLive(state MachineState) bool {
+	r.mu.Lock()
+	disabled := r.disabled
+	r.mu.Unlock()
+
+	if disabled || GetMachine().Tilted {
+		return false
+	}
+
+	states := r.States
+	if len(states) == 0 {
+		states = defaultAutofireStates
+	}
+	for _, s := range states {
+		if s == state {
+			return true
+		}
+	}
+	return false
+}
+
+// fire pulses the coil for a press of the switch, unless it is within the debounce or recycle time
+func (r *AutofireRule) fire() {
+	now := time.Now()
+
+	r.mu.Lock()
+	if now.Sub(r.lastPress) < r.Debounce {
+		r.mu.Unlock()
+		return
+	}
+	r.lastPress = now
+
+	if now.Sub(r.lastFire) < r.Recycle {
+		r.mu.Unlock()
+		return
+	}
+	r.lastFire = now
+	r.fires++
+	r.mu.Unlock()
+
+	SolenoidFire(r.Coil)
+}
+
+func (r *AutofireRule) sendEnable(live bool) {
+	r.mu.Lock()
+	r.live = live
+	r.mu.Unlock()
+
+	on := 0
+	if live {
+		on = 1
+	}
+	sendSolenoid(deviceMessage{raw: []byte{sduAutofireEnable, byte(r.Switch), byte(on)}})
+}
+
+func sendAutofireRule(r *AutofireRule) {
+	log.Debugf("autofire: sending %s to the SDU", r.Name)
+	sendSolenoid(deviceMessage{raw: []byte{
+		sduAutofireRule,
+		byte(r.Switch),
+		byte(r.Coil),
+		byte(r.Debounce / time.Millisecond),
+		byte(r.Recycle / (10 * time.Millisecond)),
+	}})
+}
+
+// autofireSwitch is called from the switch read loop for every switch event
+func autofireSwitch(sw SwitchEvent) {
+	g := GetMachine()
+	if !sw.Pressed || g.SDUAutofire || g.TestMode {
+		return
+	}
+
+	autofireRulesMu.Lock()
+	rules := append([]*AutofireRule(nil), autofireRules...)
+	autofireRulesMu.Unlock()
+
+	state := GetState()
+	for _, r := range rules {
+		if r.Switch == sw.SwitchID && r.isLive(state) {
+			r.fire()
+		}
+	}
+}
+
+// autofireStateChange is called on every state transition to turn the SDU rules on and off
+func autofireStateChange(to MachineState) {
+	if !GetMachine().SDUAutofire {
+		return
+	}
+
+	autofireRulesMu.Lock()
+	rules := append([]*AutofireRule(nil), autofireRules...)
+	autofireRulesMu.Unlock()
+
+	for _, r := range rules {
+		live := r.isLive(to)
+
+		r.mu.Lock()
+		changed := live != r.live
+		r.mu.Unlock()
+
+		if changed {
+			r.sendEnable(live)
+		}
+	}
+}
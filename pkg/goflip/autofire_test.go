@@ -0,0 +1,117 @@
+package goflip
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRegisterAutofireLimits(t *testing.T) {
+	tests := []struct {
+		name     string
+		debounce time.Duration
+		recycle  time.Duration
+		wantErr  bool
+	}{
+		{"none", 0, 0, false},
+		{"most the SDU takes", maxAutofireDebounce, maxAutofireRecycle, false},
+		{"debounce too long", 300 * time.Millisecond, 0, true},
+		{"recycle too long", 0, 3 * time.Second, true},
+		{"negative debounce", -time.Millisecond, 0, true},
+	}
+
+	for _, tt := range tests {
+		err := RegisterAutofire(&AutofireRule{Name: "limits " + tt.name, Switch: 50, Coil: 50, Debounce: tt.debounce, Recycle: tt.recycle})
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: RegisterAutofire() error = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestAutofireIsLive(t *testing.T) {
+	g := GetMachine()
+	saved := g.Tilted
+	defer func() { g.Tilted = saved }()
+
+	tests := []struct {
+		name     string
+		states   []MachineState
+		disabled bool
+		tilted   bool
+		state    MachineState
+		want     bool
+	}{
+		{"default ball in play", nil, false, false, StateBallInPlay, true},
+		{"default ball starting", nil, false, false, StateBallStarting, true},
+		{"default attract", nil, false, false, StateAttract, false},
+		{"tilted", nil, false, true, StateBallInPlay, false},
+		{"disabled", nil, true, false, StateBallInPlay, false},
+		{"own states", []MachineState{StateAttract}, false, false, StateAttract, true},
+		{"not in own states", []MachineState{StateAttract}, false, false, StateBallInPlay, false},
+	}
+
+	for _, tt := range tests {
+		g.Tilted = tt.tilted
+		r := &AutofireRule{Name: tt.name, States: tt.states, disabled: tt.disabled}
+		if got := r.isLive(tt.state); got != tt.want {
+			t.Errorf("%s: isLive(%v) = %v, want %v", tt.name, tt.state, got, tt.want)
+		}
+	}
+}
+
+func TestAutofireDebounceAndRecycle(t *testing.T) {
+	taken := testSolenoids(t)
+
+	r := &AutofireRule{Name: "sling", Switch: 51, Coil: 51, Debounce: 20 * time.Millisecond, Recycle: 100 * time.Millisecond}
+
+	steps := []struct {
+		wait time.Duration //before the press
+		want int           //fires so far
+	}{
+		{0, 1},
+		{0, 1},                     //bounce
+		{35 * time.Millisecond, 1}, //past the debounce, still recycling
+		{70 * time.Millisecond, 2}, //past both
+		{5 * time.Millisecond, 2},  //bounce
+	}
+
+	for i, s := range steps {
+		time.Sleep(s.wait)
+		r.fire()
+		if got := r.Fires(); got != s.want {
+			t.Fatalf("press %d: %d fires, want %d", i+1, got, s.want)
+		}
+	}
+
+	if got := taken(); len(got) != 2 {
+		t.Errorf("sent %v, want 2 pulses", got)
+	}
+}
+
+func TestAutofireSentAtInit(t *testing.T) {
+	taken := testSolenoids(t)
+	g := GetMachine()
+	saved := g.SDUAutofire
+	defer func() { g.SDUAutofire = saved }()
+	g.SDUAutofire = true
+
+	const sw = 52
+	if err := RegisterAutofire(&AutofireRule{Name: "pop", Switch: sw, Coil: 53, Debounce: maxAutofireDebounce, Recycle: maxAutofireRecycle}); err != nil {
+		t.Fatal(err)
+	}
+	if got := taken(); len(got) > 0 {
+		t.Fatalf("sent %v before Init, want nothing", got)
+	}
+
+	sendAutofireRules()
+	var rule []byte
+	for _, msg := range taken() {
+		if len(msg.raw) == 5 && msg.raw[0] == sduAutofireRule && msg.raw[1] == sw {
+			rule = msg.raw
+		}
+	}
+
+	want := []byte{sduAutofireRule, sw, 53, 255, 255}
+	if string(rule) != string(want) {
+		t.Errorf("rule sent as %v, want %v", rule, want)
+	}
+}
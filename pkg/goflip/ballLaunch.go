@@ -0,0 +1,66 @@
+package goflip
+
+import (
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+ballLaunch detects when the ball leaves the shooter lane. The launch is detected when
+the first of LaunchConfig.PlayfieldSwitches is pressed. If no playfield switches are
+configured, the shooter lane switch opening is treated as the launch instead.
+BallLaunched is sent to the Observers once per ball.
+*/
+
+// NoSwitch can be used for any switch setting that is not on the machine
+const NoSwitch = -1
+
+// LaunchConfig holds the switches used to detect a ball launch
+type LaunchConfig struct {
+	ShooterLaneSwitch int   //switch the ball rests on in the shooter lane. NoSwitch if there isn't one
+	PlayfieldSwitches []int //switches that the ball will hit first after leaving the shooter lane
+}
+
+// ballLaunchSwitch checks if the switch event means the ball in play was just launched
+func ballLaunchSwitch(sw SwitchEvent) {
+	g := GetMachine()
+
+	if g.ballLaunched || GetState() != StateBallStarting {
+		return
+	}
+
+	cfg := g.LaunchConfig
+	launched := false
+
+	if len(cfg.PlayfieldSwitches) > 0 {
+		launched = sw.Pressed && containsInt(cfg.PlayfieldSwitches, sw.SwitchID)
+	} else if cfg.ShooterLaneSwitch != NoSwitch {
+		launched = !sw.Pressed && sw.SwitchID == cfg.ShooterLaneSwitch
+	}
+
+	if launched {
+		BallLaunched()
+	}
+}
+
+// BallLaunched is called when the ball in play leaves the shooter lane. goflip calls this when
+// the launch is detected, but it can be called by the game as well (auto launch for example)
+func BallLaunched() {
+	g := GetMachine()
+
+	if g.TestMode || g.ballLaunched {
+		return
+	}
+
+	log.Debugln("BallLaunched() called")
+	g.ballLaunched = true
+
+	if GetState() == StateBallStarting {
+		changeState(StateBallInPlay)
+	}
+
+	startSkillShot()
+
+	for _, f := range g.Observers {
+		f.BallLaunched()
+	}
+}
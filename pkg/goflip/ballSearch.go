@@ -0,0 +1,268 @@
+package goflip
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+ballSearch watches the playfield switches while a ball is in play. If no playfield
+switch has fired for BallSearchConfig.Timeout, the configured coils are pulsed one
+after the other in rounds. Every round pulses each coil one more time than the last
+(up to maxBallSearchPulses) to shake loose a ball that is stuck. Searching stops as
+soon as any playfield switch fires. After MaxRounds the configured LostBallAction is
+taken.
+*/
+
+// BallSearchAction is what goflip does when the ball search gives up
+type BallSearchAction int
+
+const (
+	BallSearchContinue BallSearchAction = iota //keep searching forever
+	BallSearchEndBall                          //treat the ball as drained and end the ball for the player
+	BallSearchLostBall                         //notify BallSearchObservers that the ball is lost, then start over
+)
+
+const (
+	ballSearchCheckMS   = 250 //how often the monitor checks for playfield inactivity
+	maxBallSearchPulses = 3   //max number of times a coil is pulsed in a single round
+)
+
+// BallSearchConfig holds the operator settings for the automatic ball search
+type BallSearchConfig struct {
+	Enabled          bool
+	Timeout          time.Duration    //playfield inactivity before a search is started
+	Coils            []int            //solenoid IDs to pulse, in order (kickouts, drop target resets, pops)
+	PulseDelay       time.Duration    //time between each coil pulse
+	RoundDelay       time.Duration    //time between each search round
+	MaxRounds        int              //rounds before LostBallAction is taken. 0 = never
+	LostBallAction   BallSearchAction //what to do after MaxRounds
+	ExcludedSwitches []int            //switches that are not playfield activity (outhole, trough, start button, coin door..)
+	HoldSwitches     []int            //while any of these are pressed, there is no search (ball in shooter lane for example)
+}
+
+// BallSearchObserver can optionally be implemented by an Observer to be notified of ball search activity
+type BallSearchObserver interface {
+	BallSearchStarted(round int) //called at the start of every search round (1 based)
+	BallSearchEnded()            //called when a playfield switch fired during a search
+	BallLost()                   //called after MaxRounds when LostBallAction is BallSearchLostBall
+}
+
+type ballSearch struct {
+	mu           sync.Mutex
+	lastActivity time.Time
+	searching    bool
+	paused       bool
+	round        int
+	cancel       chan bool
+}
+
+var search ballSearch
+
+// ResetBallSearch restarts the inactivity timer and stops any search in progress
+func ResetBallSearch() {
+	search.mu.Lock()
+	defer search.mu.Unlock()
+
+	search.lastActivity = time.Now()
+	search.round = 0
+	search.stop()
+}
+
+// PauseBallSearch stops any search in progress and keeps a new search from starting until ResumeBallSearch is called.
+// Use this while a ball is intentionally held on the playfield.
+func PauseBallSearch() {
+	search.mu.Lock()
+	defer search.mu.Unlock()
+
+	search.paused = true
+	search.stop()
+}
+
+// ResumeBallSearch allows the ball search to start again, restarting the inactivity timer
+func ResumeBallSearch() {
+	search.mu.Lock()
+	search.paused = false
+	search.mu.Unlock()
+
+	ResetBallSearch()
+}
+
+// IsBallSearching returns true if a ball search is in progress
+func IsBallSearching() bool {
+	search.mu.Lock()
+	defer search.mu.Unlock()
+	return search.searching
+}
+
+// stop needs to be called with the lock held
+func (b *ballSearch) stop() {
+	if !b.searching {
+		return
+	}
+
+	b.searching = false
+	close(b.cancel)
+}
+
+// ballSearchSwitch is called for every switch event received from the switch matrix
+func ballSearchSwitch(sw SwitchEvent) {
+	g := GetMachine()
+	if containsInt(g.BallSearchConfig.ExcludedSwitches, sw.SwitchID) {
+		return
+	}
+
+	search.mu.Lock()
+	wasSearching := search.searching
+	search.mu.Unlock()
+
+	ResetBallSearch()
+
+	if wasSearching {
+		log.Debugf("ballSearch: switch %d fired, ending search", sw.SwitchID)
+		for _, f := range g.Observers {
+			if o, ok := f.(BallSearchObserver); ok {
+				o.BallSearchEnded()
+			}
+		}
+	}
+}
+
+// ballSearchAllowed returns true if a ball is on the playfield and could be searched for
+func ballSearchAllowed() bool {
+	g := GetMachine()
+
+	if !g.BallSearchConfig.Enabled || g.TestMode || g.Quitting {
+		return false
+	}
+
+	if state := GetState(); (state != StateBallStarting && state != StateBallInPlay) || g.BallInPlay == 0 {
+		return false
+	}
+
+	for _, sw := range g.BallSearchConfig.HoldSwitches {
+		if SwitchPressed(sw) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ballSearchMonitor runs for the life of the application checking for playfield inactivity
+func ballSearchMonitor() {
+	g := GetMachine()
+	ResetBallSearch()
+
+	for !g.Quitting {
+		time.Sleep(time.Millisecond * ballSearchCheckMS)
+
+		search.mu.Lock()
+		if !ballSearchAllowed() || search.paused {
+			search.lastActivity = time.Now()
+			search.round = 0
+			search.stop()
+			search.mu.Unlock()
+			continue
+		}
+
+		if search.searching || time.Since(search.lastActivity) < g.BallSearchConfig.Timeout {
+			search.mu.Unlock()
+			continue
+		}
+
+		search.round++
+		search.searching = true
+		search.cancel = make(chan bool)
+		round := search.round
+		cancel := search.cancel
+		search.mu.Unlock()
+
+		runBallSearchRound(round, cancel)
+	}
+}
+
+// runBallSearchRound pulses the configured coils for a single round. Returns early if the search was cancelled
+func runBallSearchRound(round int, cancel chan bool) {
+	g := GetMachine()
+	cfg := g.BallSearchConfig
+
+	log.Infof("ballSearch: starting round %d", round)
+	for _, f := range g.Observers {
+		if o, ok := f.(BallSearchObserver); ok {
+			o.BallSearchStarted(round)
+		}
+	}
+
+	pulses := round
+	if pulses > maxBallSearchPulses {
+		pulses = maxBallSearchPulses
+	}
+
+	for _, coil := range cfg.Coils {
+		for i := 0; i < pulses; i++ {
+			select {
+			case <-cancel:
+				return
+			default:
+			}
+
+			SolenoidFire(coil)
+
+			select {
+			case <-cancel:
+				return
+			case <-time.After(cfg.PulseDelay):
+			}
+		}
+	}
+
+	select {
+	case <-cancel:
+		return
+	case <-time.After(cfg.RoundDelay):
+	}
+
+	search.mu.Lock()
+	if !search.searching {
+		//a switch fired at the very end of the round
+		search.mu.Unlock()
+		return
+	}
+	search.searching = false
+	giveUp := cfg.MaxRounds > 0 && round >= cfg.MaxRounds && cfg.LostBallAction != BallSearchContinue
+	if giveUp {
+		search.round = 0
+		search.lastActivity = time.Now()
+	}
+	search.mu.Unlock()
+
+	if !giveUp {
+		return
+	}
+
+	switch cfg.LostBallAction {
+	case BallSearchEndBall:
+		log.Warnln("ballSearch: ball not found, ending ball")
+		BallDrained()
+		ChangePlayerState(EndPlayer)
+	case BallSearchLostBall:
+		log.Warnln("ballSearch: ball not found, declaring ball lost")
+		for _, f := range g.Observers {
+			if o, ok := f.(BallSearchObserver); ok {
+				o.BallLost()
+			}
+		}
+	}
+}
+
+func containsInt(list []int, val int) bool {
+	for _, v := range list {
+		if v == val {
+			return true
+		}
+	}
+	return false
+}
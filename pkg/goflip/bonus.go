@@ -0,0 +1,205 @@
+package goflip
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+bonus is the end of ball bonus. Observers register the bonus line items at Init, then
+add to them (and to the bonus multiplier) during the ball. When the ball drains, each
+line item is counted down into the player's score (once per multiplier) before the
+PlayerEnd event is sent. Nothing is counted if the ball was tilted.
+*/
+
+const maxBonusCountDisplay = 99 //the ball in play display is only 2 digits
+
+// BonusItem is a single line of the end of ball bonus
+type BonusItem struct {
+	Name  string
+	Value int   //points awarded for each count of this item
+	Lamps []int //lamps fast blinked while this item is counted
+	Sound byte  //sound played for each count. 0 for none
+}
+
+// BonusConfig holds the timing of the bonus count
+type BonusConfig struct {
+	StepDelay time.Duration //time between each count
+	ItemDelay time.Duration //pause after an item has been counted
+}
+
+// BonusObserver can optionally be implemented by an Observer to be told as each bonus item is counted
+type BonusObserver interface {
+	BonusCounted(name string, count int, points int)
+}
+
+type bonusLine struct {
+	item  BonusItem
+	count int
+}
+
+type bonusState struct {
+	mu         sync.Mutex
+	lines      []*bonusLine
+	multiplier int
+}
+
+var bonus = bonusState{multiplier: 1}
+
+// RegisterBonusItem adds a line item to the bonus. Items are counted in the order they are registered
+func RegisterBonusItem(item BonusItem) {
+	bonus.mu.Lock()
+	defer bonus.mu.Unlock()
+
+	for _, l := range bonus.lines {
+		if l.item.Name == item.Name {
+			l.item = item
+			return
+		}
+	}
+	bonus.lines = append(bonus.lines, &bonusLine{item: item})
+}
+
+// AddBonus adds count to the named bonus item for the ball in play
+func AddBonus(name string, count int) {
+	bonus.mu.Lock()
+	defer bonus.mu.Unlock()
+
+	for _, l := range bonus.lines {
+		if l.item.Name == name {
+			l.count += count
+			return
+		}
+	}
+	log.Warnf("bonus: AddBonus called for unregistered item %s", name)
+}
+
+// BonusCount returns the current count of the named bonus item
+func BonusCount(name string) int {
+	bonus.mu.Lock()
+	defer bonus.mu.Unlock()
+
+	for _, l := range bonus.lines {
+		if l.item.Name == name {
+			return l.count
+		}
+	}
+	return 0
+}
+
+// SetBonusMultiplier sets the bonus multiplier for the ball in play
+func SetBonusMultiplier(multiplier int) {
+	bonus.mu.Lock()
+	defer bonus.mu.Unlock()
+
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	bonus.multiplier = multiplier
+}
+
+// AddBonusMultiplier increases the bonus multiplier for the ball in play
+func AddBonusMultiplier(add int) {
+	SetBonusMultiplier(BonusMultiplier() + add)
+}
+
+// BonusMultiplier returns the current bonus multiplier
+func BonusMultiplier() int {
+	bonus.mu.Lock()
+	defer bonus.mu.Unlock()
+	return bonus.multiplier
+}
+
+// ClearBonus resets all of the bonus counts and the multiplier
+func ClearBonus() {
+	bonus.mu.Lock()
+	defer bonus.mu.Unlock()
+
+	for _, l := range bonus.lines {
+		l.count = 0
+	}
+	bonus.multiplier = 1
+}
+
+// countBonus counts the bonus into the current player's score. This blocks until the count is complete
+func countBonus() {
+	g := GetMachine()
+	defer ClearBonus()
+
+	if g.Tilted {
+		log.Debugln("bonus: ball was tilted, no bonus")
+		return
+	}
+
+	bonus.mu.Lock()
+	lines := make([]bonusLine, len(bonus.lines))
+	for i, l := range bonus.lines {
+		lines[i] = *l
+	}
+	multiplier := bonus.multiplier
+	bonus.mu.Unlock()
+
+	for _, l := range lines {
+		if l.count <= 0 {
+			continue
+		}
+
+		LampFastBlink(l.item.Lamps...)
+
+		points := 0
+		for pass := 0; pass < multiplier; pass++ {
+			for c := l.count; c > 0; c-- {
+				if GetState() != StateBonus {
+					//game was aborted
+					LampOff(l.item.Lamps...)
+					return
+				}
+
+				addScore(l.item.Value, NoSwitch, false) //bonus has its own multiplier
+				points += l.item.Value
+
+				remaining := c - 1
+				if remaining > maxBonusCountDisplay {
+					remaining = maxBonusCountDisplay
+				}
+				SetBallInPlayDisp(int8(remaining))
+				if l.item.Sound > 0 {
+					PlaySound(l.item.Sound)
+				}
+				time.Sleep(g.BonusConfig.StepDelay)
+			}
+		}
+
+		LampOff(l.item.Lamps...)
+		log.Debugf("bonus: %s counted %d x%d for %d points", l.item.Name, l.count, multiplier, points)
+
+		sendBonusStat(l.item.Name, l.count, points)
+		for _, f := range g.Observers {
+			if o, ok := f.(BonusObserver); ok {
+				o.BonusCounted(l.item.Name, l.count, points)
+			}
+		}
+
+		time.Sleep(g.BonusConfig.ItemDelay)
+	}
+
+	SetBallInPlayDisp(int8(g.BallInPlay))
+}
+
+func sendBonusStat(name string, count int, points int) {
+	stat := struct {
+		Name   string
+		Count  int
+		Points int
+	}{name, count, points}
+
+	b, err := json.Marshal(stat)
+	if err != nil {
+		log.Errorln("Error in marshalling:", err)
+		return
+	}
+	Broadcast("bonus", string(b))
+}
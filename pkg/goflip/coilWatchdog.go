@@ -0,0 +1,304 @@
+package goflip
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+coilWatchdog keeps the coils from burning up, once CoilSafetyConfig.Enabled is set. It
+tracks how long each coil has been held on and how much it has been on over the last
+CoilSafetyConfig.Window, and:
+
+  - turns off a coil that has been held on longer than its MaxOnTime
+  - drops pulses that would take a coil over its MaxDuty for the window
+  - turns off every coil at Quit, and if the switch loop or the scheduler is held up
+    for HangTimeout
+  - sends a keepalive to the SDU (SDUKeepAlive), for firmware that turns the coils
+    off without one
+
+MaxOnTime and MaxDuty come from the coil's CoilProfile, or CoilSafetyConfig if the
+profile doesn't set them. A coil that is meant to be held (flipper holds, GI) should
+have NoLimit as its MaxOnTime. Every trip is logged, sent to the web interface and
+passed to CoilSafetyObservers.
+*/
+
+const (
+	coilWatchdogTick = 50 * time.Millisecond
+	heartbeatTimer   = "coilWatchdog:heartbeat"
+	sduKeepAlive     = 0xfb //SDU extended message: keepalive
+	NoLimit          = -1   //MaxOnTime or MaxDuty with no limit
+)
+
+// CoilSafetyConfig holds the default coil limits
+type CoilSafetyConfig struct {
+	Enabled     bool
+	MaxOnTime   time.Duration //longest a coil can be held on
+	MaxDuty     int           //most percent of Window a coil can be on for
+	Window      time.Duration //rolling window the duty is measured over
+	HangTimeout time.Duration //all coils are turned off if the switch loop or scheduler is held up this long
+
+	SDUKeepAlive bool //send the keepalive, for SDU firmware that turns the coils off when it stops
+}
+
+// CoilAlert is sent when a coil limit trips
+type CoilAlert struct {
+	Coil   int
+	Reason string
+	Time   time.Time
+}
+
+// CoilSafetyObserver can optionally be implemented by an Observer to be told when a coil limit trips
+type CoilSafetyObserver interface {
+	CoilSafetyTripped(alert CoilAlert)
+}
+
+type coilPulse struct {
+	start time.Time
+	d     time.Duration
+}
+
+type coilUsage struct {
+	held    bool
+	onSince time.Time
+	pulses  []coilPulse
+}
+
+var coilUsages = make(map[int]*coilUsage)
+
+// heartbeat is how the watchdog knows the rest of goflip is still running
+var heartbeat struct {
+	mu          sync.Mutex
+	switchBusy  time.Time //when the switch loop started on its last switches, zero while it is waiting for more
+	schedulerAt time.Time //last time the scheduler ran the heartbeat timer
+}
+
+// AllCoilsOff turns off every coil that is held on
+func AllCoilsOff() {
+	coilsMu.Lock()
+	var held []int
+	for id, u := range coilUsages {
+		if u.held {
+			held = append(held, id)
+		}
+	}
+	coilsMu.Unlock()
+
+	for _, id := range held {
+		SolenoidOff(id)
+	}
+}
+
+// coilLimits returns the MaxOnTime and MaxDuty for the coil. Called with coilsMu held
+func coilLimits(solID int) (time.Duration, int) {
+	cfg := GetMachine().CoilSafetyConfig
+	maxOn, maxDuty := cfg.MaxOnTime, cfg.MaxDuty
+
+	if p, ok := coilProfiles[solID]; ok {
+		if p.MaxOnTime != 0 {
+			maxOn = p.MaxOnTime
+		}
+		if p.MaxDuty != 0 {
+			maxDuty = p.MaxDuty
+		}
+	}
+	return maxOn, maxDuty
+}
+
+// setCoilNoOnLimit lets the coil be held on for as long as it is needed
+func setCoilNoOnLimit(solID int) {
+	coilsMu.Lock()
+	defer coilsMu.Unlock()
+
+	p := coilProfiles[solID]
+	p.MaxOnTime = NoLimit
+	coilProfiles[solID] = p
+}
+
+// usage returns the tracking for the coil. Called with coilsMu held
+func usage(solID int) *coilUsage {
+	u, ok := coilUsages[solID]
+	if !ok {
+		u = &coilUsage{}
+		coilUsages[solID] = u
+	}
+	return u
+}
+
+// onTime returns how long the coil has been on in the window up to now. Pulses still running are counted
+// in full, so a burst of pulses can't get past the duty limit. Called with coilsMu held
+func (u *coilUsage) onTime(now time.Time, window time.Duration) time.Duration {
+	from := now.Add(-window)
+
+	kept := u.pulses[:0]
+	var total time.Duration
+	for _, p := range u.pulses {
+		end := p.start.Add(p.d)
+		if end.Before(from) {
+			continue
+		}
+		kept = append(kept, p)
+
+		start := p.start
+		if start.Before(from) {
+			start = from
+		}
+		total += end.Sub(start)
+	}
+	u.pulses = kept
+
+	if u.held {
+		start := u.onSince
+		if start.Before(from) {
+			start = from
+		}
+		total += now.Sub(start)
+	}
+	return total
+}
+
+// coilDutyOK returns true, and records the pulse, if pulsing the coil for d keeps it under its MaxDuty
+func coilDutyOK(solID int, d time.Duration) bool {
+	cfg := GetMachine().CoilSafetyConfig
+	if !cfg.Enabled || cfg.Window <= 0 {
+		return true
+	}
+
+	coilsMu.Lock()
+	_, maxDuty := coilLimits(solID)
+	now := time.Now()
+	u := usage(solID)
+	on := u.onTime(now, cfg.Window) + d
+
+	if maxDuty != NoLimit && on*100 > cfg.Window*time.Duration(maxDuty) {
+		coilsMu.Unlock()
+		coilTripped(solID, fmt.Sprintf("over %d%% duty, pulse dropped", maxDuty))
+		return false
+	}
+
+	u.pulses = append(u.pulses, coilPulse{start: now, d: d})
+	coilsMu.Unlock()
+	return true
+}
+
+// coilHeld records that the coil was turned on (or off) until told otherwise
+func coilHeld(solID int, on bool) {
+	coilsMu.Lock()
+	defer coilsMu.Unlock()
+
+	u := usage(solID)
+	now := time.Now()
+	if on && !u.held {
+		u.onSince = now
+	}
+	if !on && u.held {
+		u.pulses = append(u.pulses, coilPulse{start: u.onSince, d: now.Sub(u.onSince)})
+	}
+	u.held = on
+}
+
+func coilTripped(solID int, reason string) {
+	alert := CoilAlert{Coil: solID, Reason: reason, Time: time.Now()}
+	log.Errorf("coilWatchdog: coil %d %s", solID, reason)
+
+	if b, err := json.Marshal(alert); err == nil {
+		Broadcast("coilAlert", string(b))
+	}
+
+	g := GetMachine()
+	for _, f := range g.Observers {
+		if o, ok := f.(CoilSafetyObserver); ok {
+			o.CoilSafetyTripped(alert)
+		}
+	}
+}
+
+// switchLoopBusy is called by the switch read loop when it starts (true) and finishes (false) handling switches
+func switchLoopBusy(busy bool) {
+	heartbeat.mu.Lock()
+	defer heartbeat.mu.Unlock()
+
+	if busy {
+		heartbeat.switchBusy = time.Now()
+	} else {
+		heartbeat.switchBusy = time.Time{}
+	}
+}
+
+func schedulerBeat() {
+	heartbeat.mu.Lock()
+	defer heartbeat.mu.Unlock()
+	heartbeat.schedulerAt = time.Now()
+}
+
+// hungFor returns what has been held up longer than timeout, and for how long. An empty string if nothing is
+func hungFor(now time.Time, timeout time.Duration) (string, time.Duration) {
+	heartbeat.mu.Lock()
+	defer heartbeat.mu.Unlock()
+
+	if !heartbeat.switchBusy.IsZero() {
+		if d := now.Sub(heartbeat.switchBusy); d > timeout {
+			return "switch loop", d
+		}
+	}
+	if d := now.Sub(heartbeat.schedulerAt); d > timeout {
+		return "scheduler", d
+	}
+	return "", 0
+}
+
+// coilWatchdog is started at Init, and checks the held coils and the heartbeats every coilWatchdogTick
+func coilWatchdog() {
+	g := GetMachine()
+	lastKeepAlive := time.Now()
+	hung := false
+
+	schedulerBeat()
+	Every(heartbeatTimer, ScopeMachine, coilWatchdogTick, schedulerBeat)
+
+	for range time.Tick(coilWatchdogTick) {
+		if g.Quitting {
+			return
+		}
+
+		cfg := g.CoilSafetyConfig
+		now := time.Now()
+
+		if cfg.SDUKeepAlive && now.Sub(lastKeepAlive) >= KeepAliveMS*time.Millisecond {
+			lastKeepAlive = now
+			sendSolenoid(deviceMessage{raw: []byte{sduKeepAlive}})
+		}
+
+		if !cfg.Enabled {
+			continue
+		}
+
+		if cfg.HangTimeout > 0 {
+			what, d := hungFor(now, cfg.HangTimeout)
+			if what != "" && !hung {
+				AllCoilsOff()
+				coilTripped(NoCoil, fmt.Sprintf("%s held up for %v, all coils off", what, d.Round(time.Millisecond)))
+			}
+			hung = what != ""
+		}
+
+		coilsMu.Lock()
+		var tooLong []int
+		for id, u := range coilUsages {
+			maxOn, _ := coilLimits(id)
+			if u.held && maxOn != NoLimit && now.Sub(u.onSince) > maxOn {
+				tooLong = append(tooLong, id)
+			}
+		}
+		coilsMu.Unlock()
+
+		for _, id := range tooLong {
+			SolenoidOff(id)
+			coilTripped(id, "held on too long, turned off")
+		}
+	}
+}
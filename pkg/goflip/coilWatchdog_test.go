@@ -0,0 +1,168 @@
+package goflip
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCoilUsageOnTime(t *testing.T) {
+	now := time.Now()
+	window := 10 * time.Second
+	ago := func(d time.Duration) time.Time { return now.Add(-d) }
+
+	tests := []struct {
+		name       string
+		usage      coilUsage
+		want       time.Duration
+		wantPulses int
+	}{
+		{
+			name: "no pulses",
+		},
+		{
+			name:       "pulses in the window",
+			usage:      coilUsage{pulses: []coilPulse{{ago(5 * time.Second), 100 * time.Millisecond}, {ago(2 * time.Second), 200 * time.Millisecond}}},
+			want:       300 * time.Millisecond,
+			wantPulses: 2,
+		},
+		{
+			name:       "pulses before the window are dropped",
+			usage:      coilUsage{pulses: []coilPulse{{ago(20 * time.Second), time.Second}, {ago(time.Second), 100 * time.Millisecond}}},
+			want:       100 * time.Millisecond,
+			wantPulses: 1,
+		},
+		{
+			name:       "pulse across the start of the window",
+			usage:      coilUsage{pulses: []coilPulse{{ago(11 * time.Second), 2 * time.Second}}},
+			want:       time.Second,
+			wantPulses: 1,
+		},
+		{
+			name:       "pulse still running",
+			usage:      coilUsage{pulses: []coilPulse{{ago(50 * time.Millisecond), 100 * time.Millisecond}}},
+			want:       100 * time.Millisecond,
+			wantPulses: 1,
+		},
+		{
+			name:  "held in the window",
+			usage: coilUsage{held: true, onSince: ago(3 * time.Second)},
+			want:  3 * time.Second,
+		},
+		{
+			name:  "held since before the window",
+			usage: coilUsage{held: true, onSince: ago(time.Minute)},
+			want:  window,
+		},
+		{
+			name:       "held and pulsed",
+			usage:      coilUsage{held: true, onSince: ago(time.Second), pulses: []coilPulse{{ago(4 * time.Second), 500 * time.Millisecond}}},
+			want:       1500 * time.Millisecond,
+			wantPulses: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		u := tt.usage
+		if got := u.onTime(now, window); got != tt.want {
+			t.Errorf("%s: onTime() = %v, want %v", tt.name, got, tt.want)
+		}
+		if len(u.pulses) != tt.wantPulses {
+			t.Errorf("%s: %d pulses kept, want %d", tt.name, len(u.pulses), tt.wantPulses)
+		}
+	}
+}
+
+func TestCoilDutyOK(t *testing.T) {
+	g := GetMachine()
+	saved := g.CoilSafetyConfig
+	defer func() { g.CoilSafetyConfig = saved }()
+
+	g.CoilSafetyConfig = CoilSafetyConfig{Enabled: true, MaxDuty: 10, Window: 10 * time.Second}
+
+	const (
+		coil     = 40
+		profiled = 41
+		strict   = 42
+	)
+	coilsMu.Lock()
+	for _, id := range []int{coil, profiled, strict} {
+		delete(coilUsages, id)
+	}
+	coilsMu.Unlock()
+
+	//10% of 10s is 1s of pulses
+	for i := 0; i < 5; i++ {
+		if !coilDutyOK(coil, 200*time.Millisecond) {
+			t.Fatalf("pulse %d dropped, want it under the duty limit", i+1)
+		}
+	}
+	if coilDutyOK(coil, 200*time.Millisecond) {
+		t.Errorf("pulse over the duty limit was allowed")
+	}
+	if coilDutyOK(coil, time.Millisecond) {
+		t.Errorf("pulse over the duty limit was allowed")
+	}
+
+	//the profile's MaxDuty is used over the config's
+	SetCoilProfile(profiled, CoilProfile{MaxDuty: NoLimit})
+	for i := 0; i < 20; i++ {
+		if !coilDutyOK(profiled, time.Second) {
+			t.Fatalf("pulse %d dropped for a coil with no duty limit", i+1)
+		}
+	}
+
+	SetCoilProfile(strict, CoilProfile{MaxDuty: 1})
+	if !coilDutyOK(strict, 100*time.Millisecond) {
+		t.Errorf("pulse at the profile's duty limit was dropped")
+	}
+	if coilDutyOK(strict, 100*time.Millisecond) {
+		t.Errorf("pulse over the profile's duty limit was allowed")
+	}
+
+	//nothing is dropped with the watchdog off
+	g.CoilSafetyConfig.Enabled = false
+	if !coilDutyOK(coil, time.Second) {
+		t.Errorf("pulse dropped with the watchdog off")
+	}
+}
+
+func TestSetCoilProfileKeepsNoOnLimit(t *testing.T) {
+	const coil = 43
+	setCoilNoOnLimit(coil)
+
+	SetCoilProfile(coil, CoilProfile{HoldDuty: 30})
+	if maxOn := coilProfiles[coil].MaxOnTime; maxOn != NoLimit {
+		t.Errorf("MaxOnTime = %v after SetCoilProfile, want NoLimit", maxOn)
+	}
+
+	SetCoilProfile(coil, CoilProfile{MaxOnTime: time.Second})
+	if maxOn := coilProfiles[coil].MaxOnTime; maxOn != time.Second {
+		t.Errorf("MaxOnTime = %v, want the profile's 1s", maxOn)
+	}
+}
+
+func TestHungFor(t *testing.T) {
+	timeout := time.Second
+	schedulerBeat()
+	switchLoopBusy(false)
+
+	now := time.Now()
+	if what, _ := hungFor(now, timeout); what != "" {
+		t.Errorf("hungFor() = %q with fresh heartbeats, want nothing held up", what)
+	}
+
+	//waiting on switches isn't held up, however long it waits
+	if what, _ := hungFor(now.Add(timeout/2), timeout); what != "" {
+		t.Errorf("hungFor() = %q with the switch loop waiting, want nothing held up", what)
+	}
+
+	switchLoopBusy(true)
+	if what, _ := hungFor(now.Add(2*timeout), timeout); what != "switch loop" {
+		t.Errorf("hungFor() = %q with the switch loop busy, want switch loop", what)
+	}
+	switchLoopBusy(false)
+
+	if what, _ := hungFor(now.Add(2*timeout), timeout); what != "scheduler" {
+		t.Errorf("hungFor() = %q with no scheduler heartbeat, want scheduler", what)
+	}
+}
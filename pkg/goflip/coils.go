@@ -0,0 +1,102 @@
+package goflip
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+coils keeps a pulse profile for each solenoid: how long it is pulsed for, how hard
+SolenoidFire hits it, what duty it is held at, and how long it needs to recycle.
+Coils without a profile get defaultCoilProfile (a 100ms pulse).
+
+The SDU short message has 3 bits for the value, so durations are sent in steps of
+sduPulseUnit: values 1-6 are pulses of 50-300ms, and 7 holds the coil on. A hold
+below 100% duty is sent as an extended message, for SDU firmware that can PWM its
+outputs (SDUCoilHold). Without it coils are held on full.
+*/
+
+const (
+	sduPulseUnit = 50 * time.Millisecond //each step of a pulse in the short message
+	sduMaxPulse  = 6                     //longest pulse, in sduPulseUnits
+	sduHoldOn    = 0x07                  //short message value that holds the coil on
+	sduCoilHold  = 0xfc                  //SDU extended message: hold, coil, duty (0-100)
+)
+
+// CoilProfile is how a solenoid is driven
+type CoilProfile struct {
+	Pulse    time.Duration //pulse time used by SolenoidFire. 0 for the default (100ms)
+	Strength int           //percent of Pulse used by SolenoidFire. 0 for 100
+	HoldDuty int           //0-100 percent the coil is held at by SolenoidHold. 0 holds it full on
+	Recycle  time.Duration //pulses sooner than this after the last one are dropped
+
+	MaxOnTime time.Duration //longest the coil can be held on. 0 for CoilSafetyConfig.MaxOnTime, NoLimit for none
+	MaxDuty   int           //most percent of the window the coil can be on. 0 for CoilSafetyConfig.MaxDuty, NoLimit for none
+}
+
+var defaultCoilProfile = CoilProfile{Pulse: 100 * time.Millisecond, Strength: 100}
+
+var (
+	coilProfiles  = make(map[int]CoilProfile)
+	coilLastPulse = make(map[int]time.Time)
+	coilsMu       sync.Mutex
+)
+
+// SetCoilProfile sets how the solenoid is driven. A coil that was already let off the MaxOnTime
+// (a registered flipper hold or GI string) keeps NoLimit unless the profile sets its own MaxOnTime
+func SetCoilProfile(solID int, p CoilProfile) {
+	coilsMu.Lock()
+	defer coilsMu.Unlock()
+
+	if p.MaxOnTime == 0 && coilProfiles[solID].MaxOnTime == NoLimit {
+		p.MaxOnTime = NoLimit
+	}
+	coilProfiles[solID] = p
+}
+
+// GetCoilProfile returns the profile for the solenoid, with the defaults filled in
+func GetCoilProfile(solID int) CoilProfile {
+	coilsMu.Lock()
+	defer coilsMu.Unlock()
+
+	p, ok := coilProfiles[solID]
+	if !ok {
+		return defaultCoilProfile
+	}
+	if p.Pulse <= 0 {
+		p.Pulse = defaultCoilProfile.Pulse
+	}
+	if p.Strength <= 0 {
+		p.Strength = defaultCoilProfile.Strength
+	}
+	return p
+}
+
+// encodePulse turns a pulse time into the SDU short message value, rounded to the nearest sduPulseUnit
+func encodePulse(d time.Duration) int {
+	units := int((d + sduPulseUnit/2) / sduPulseUnit)
+	if units < 1 {
+		units = 1
+	}
+	if units > sduMaxPulse {
+		log.Warnf("coils: pulse of %v is longer than the SDU supports, sending %v", d, sduMaxPulse*sduPulseUnit)
+		units = sduMaxPulse
+	}
+	return units
+}
+
+// coilRecycled returns true, and starts the recycle time, if the solenoid can be pulsed again
+func coilRecycled(solID int, recycle time.Duration) bool {
+	coilsMu.Lock()
+	defer coilsMu.Unlock()
+
+	now := time.Now()
+	if recycle > 0 && now.Sub(coilLastPulse[solID]) < recycle {
+		log.Debugf("coils: %d pulsed before its recycle time, dropped", solID)
+		return false
+	}
+	coilLastPulse[solID] = now
+	return true
+}
@@ -0,0 +1,43 @@
+package goflip
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEncodePulse(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want int
+	}{
+		{0, 1},
+		{-time.Second, 1},
+		{10 * time.Millisecond, 1},
+		{24 * time.Millisecond, 1},
+		{25 * time.Millisecond, 1},
+		{50 * time.Millisecond, 1},
+		{74 * time.Millisecond, 1},
+		{75 * time.Millisecond, 2},
+		{100 * time.Millisecond, 2},
+		{149 * time.Millisecond, 3},
+		{250 * time.Millisecond, 5},
+		{300 * time.Millisecond, 6},
+		{324 * time.Millisecond, 6},
+		{325 * time.Millisecond, 6},
+		{time.Second, 6},
+	}
+
+	for _, tt := range tests {
+		if got := encodePulse(tt.d); got != tt.want {
+			t.Errorf("encodePulse(%v) = %d, want %d", tt.d, got, tt.want)
+		}
+	}
+}
+
+func TestEncodePulseNeverHolds(t *testing.T) {
+	for d := time.Duration(0); d <= time.Second; d += 10 * time.Millisecond {
+		if got := encodePulse(d); got < 1 || got > sduMaxPulse {
+			t.Fatalf("encodePulse(%v) = %d, want 1 to %d", d, got, sduMaxPulse)
+		}
+	}
+}
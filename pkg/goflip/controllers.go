@@ -3,6 +3,7 @@ package goflip
 //JAF TODO... need to send a keepalive to each arduino
 
 import (
+	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -10,6 +11,12 @@ import (
 
 const KeepAliveMS = 250
 
+var (
+	solenoidsRunning bool //set by Init once the SolenoidSubscriber is started
+	solenoidsClosed  bool //set by Quit once the SolenoidSubscriber has been told to stop
+	solenoidSendMu   sync.Mutex
+)
+
 func LampSubscriber() {
 	g := GetMachine()
 	log.Debugln("Starting LDU subscribing")
@@ -48,6 +55,12 @@ func SolenoidSubscriber() {
 			return
 		}
 
+		g.devices.sdu.extended = g.sduExtended()
+		if len(msg.raw) > 0 {
+			g.devices.sdu.SendExtended(msg.raw)
+			continue
+		}
+
 		log.Debugf("Solenoid Msg id:%d value:%d\n", msg.id, msg.value)
 		//select {
 		//case msg := <-g.SolenoidControl:
@@ -71,21 +84,78 @@ func SolenoidSubscriber() {
 	}
 }
 
+// startSolenoids starts the SolenoidSubscriber
+func startSolenoids() {
+	solenoidSendMu.Lock()
+	solenoidsRunning = true
+	solenoidSendMu.Unlock()
+
+	go SolenoidSubscriber()
+}
+
+// solenoidsStarted returns true once messages can be sent to the SolenoidSubscriber
+func solenoidsStarted() bool {
+	solenoidSendMu.Lock()
+	defer solenoidSendMu.Unlock()
+	return solenoidsRunning
+}
+
+// sduExtended returns true if the SDU firmware takes extended messages, which it does if any of the SDU features are set
+func (g *GoFlip) sduExtended() bool {
+	return g.SDUAutofire || g.SDUFlipperEnable || g.SDUCoilHold || g.CoilSafetyConfig.SDUKeepAlive
+}
+
+// sendSolenoid passes the message to the SolenoidSubscriber. Messages sent after Quit are dropped, as
+// nothing is reading them
+func sendSolenoid(msg deviceMessage) {
+	solenoidSendMu.Lock()
+	defer solenoidSendMu.Unlock()
+
+	if solenoidsClosed {
+		log.Debugf("Solenoid Msg id:%d dropped, quitting", msg.id)
+		return
+	}
+	solenoidControl <- msg
+}
+
+// SetLampState sets the state of the lamp in the base game layer. Modes, lamp shows and service mode show over this
 func SetLampState(lampID int, state int) {
 	g := GetMachine()
-	if _, ok := g.lampStates[lampID]; !ok {
-		g.lampStates[lampID] = state
-	} else {
-		g.lampStates[lampID] = state
+	g.lampMu.Lock()
+	g.lampStates[lampID] = state
+	g.lampMu.Unlock()
+
+	refreshLamp(lampID)
+}
+
+// refreshLamp sends the state that should be showing for the lamp to the LDU. RGB lamps are drawn by the ledSubscriber
+func refreshLamp(lampID int) {
+	state := GetLampState(lampID)
+	trackLampPattern(lampID, state)
+
+	if IsRGBLamp(lampID) {
+		return
 	}
 
 	var msg deviceMessage
 	msg.id = lampID
-	msg.value = state
+	msg.value = resolveLampPattern(lampID, state, time.Now())
 
 	lampControl <- msg
 }
 
+// baseLampState returns the state of the lamp set by the base game, ignoring the layers above it
+func baseLampState(lampID int) int {
+	g := GetMachine()
+	g.lampMu.Lock()
+	defer g.lampMu.Unlock()
+
+	if state, ok := g.lampStates[lampID]; ok {
+		return state
+	}
+	return Off
+}
+
 func LampOn(lampID ...int) {
 	for _, l := range lampID {
 		SetLampState(l, On)
@@ -112,57 +182,69 @@ func LampFastBlink(lampID ...int) {
 }
 
 func SolenoidOff(solID int) {
+	coilHeld(solID, false)
+
 	var msg deviceMessage
 	msg.id = solID
 	msg.value = Off
 
-	solenoidControl <- msg
+	sendSolenoid(msg)
 }
-func SolenoidFire(solID int) {
-	var msg deviceMessage
-	msg.id = solID
-	msg.value = 2 //should be about a 100ms pule when at 2
 
-	solenoidControl <- msg
+// SolenoidFire pulses the solenoid using its CoilProfile (Pulse at Strength)
+func SolenoidFire(solID int) {
+	p := GetCoilProfile(solID)
+	SolenoidPulse(solID, p.Pulse*time.Duration(p.Strength)/100)
 }
 
+// SolenoidAlwaysOn turns the solenoid on full until SolenoidOff is called
 func SolenoidAlwaysOn(solID int) {
+	coilHeld(solID, true)
+
 	var msg deviceMessage
 	msg.id = solID
-	msg.value = 0x07
+	msg.value = sduHoldOn
 
-	solenoidControl <- msg
+	sendSolenoid(msg)
 }
 
-func FlipperControl(on bool) {
-	var msg deviceMessage
-	msg.id = 0x0f
-	if on {
-		msg.value = 0x03
-	} else {
-		msg.value = 0x02
+// SolenoidHold turns the solenoid on, held at the HoldDuty of its CoilProfile until SolenoidOff is called.
+// Without SDUCoilHold the SDU can't hold below 100%, and the solenoid is turned on full
+func SolenoidHold(solID int) {
+	p := GetCoilProfile(solID)
+	if p.HoldDuty <= 0 || p.HoldDuty >= 100 || !GetMachine().SDUCoilHold {
+		SolenoidAlwaysOn(solID)
+		return
 	}
 
-	solenoidControl <- msg
+	coilHeld(solID, true)
+	sendSolenoid(deviceMessage{raw: []byte{sduCoilHold, byte(solID), byte(p.HoldDuty)}})
 }
 
+// SolenoidOnDuration sends duration to the SDU as is: 1-6 pulse the solenoid in 50ms steps, 7 holds it on.
+//
+// Deprecated: use SolenoidPulse, which honors the solenoid's CoilProfile and the coil watchdog.
 func SolenoidOnDuration(solID int, duration int) {
 	var msg deviceMessage
 	msg.id = solID
 	msg.value = duration
-	solenoidControl <- msg
+	sendSolenoid(msg)
 }
 
-func SwitchPressed(swID int) bool {
-	g := GetMachine()
-	return g.switchStates[swID]
+// SolenoidPulse pulses the solenoid for d, unless it is still within the Recycle time of its CoilProfile
+// or the pulse would take it over its duty limit
+func SolenoidPulse(solID int, d time.Duration) {
+	if !coilRecycled(solID, GetCoilProfile(solID).Recycle) || !coilDutyOK(solID, d) {
+		return
+	}
+
+	var msg deviceMessage
+	msg.id = solID
+	msg.value = encodePulse(d)
+	sendSolenoid(msg)
 }
 
-func GetLampState(lampID int) int {
+func SwitchPressed(swID int) bool {
 	g := GetMachine()
-	if state, ok := g.lampStates[lampID]; ok {
-		return state
-	}
-	return Off
-
+	return g.switchStates[swID]
 }
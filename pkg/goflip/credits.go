@@ -0,0 +1,44 @@
+package goflip
+
+import (
+	log "github.com/sirupsen/logrus"
+)
+
+// NoCoil can be used for any coil setting that is not connected on the machine
+const NoCoil = -1
+
+const maxCreditDisplay = 99 //the credit display is only 2 digits
+
+// AddCredits adds credits to the machine (up to MaxCredits) and updates the credit display
+func AddCredits(credits int) {
+	g := GetMachine()
+
+	g.Credits += credits
+	if g.MaxCredits > 0 && g.Credits > g.MaxCredits {
+		g.Credits = g.MaxCredits
+	}
+	if g.Credits < 0 {
+		g.Credits = 0
+	}
+
+	log.Debugf("credits: now at %d", g.Credits)
+	showCredits()
+}
+
+// FireKnocker pulses the knocker coil, if the machine has one
+func FireKnocker() {
+	g := GetMachine()
+	if g.KnockerCoil == NoCoil {
+		return
+	}
+	SolenoidFire(g.KnockerCoil)
+}
+
+func showCredits() {
+	g := GetMachine()
+	credits := g.Credits
+	if credits > maxCreditDisplay {
+		credits = maxCreditDisplay
+	}
+	SetCreditDisp(int8(credits))
+}
@@ -0,0 +1,139 @@
+package goflip
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+flashers are outputs that are only ever pulsed: each flash turns the output on for the
+flasher's Pulse time. A flash that comes sooner than MinInterval after the last one is
+dropped, so rules code can't overheat the bulb (or coil driver) by flashing too often.
+
+FlashPattern plays a pattern of flashes, one character per step:
+
+	FlashPattern("pops", "1101000", 50*time.Millisecond, 3)
+
+'1' flashes on that step and anything else waits. The pattern is repeated the number of
+times passed in, 0 for forever (until StopFlasher is called).
+*/
+
+const defaultFlashPulse = 30 * time.Millisecond
+
+// Flasher is a pulse driven output
+type Flasher struct {
+	Name        string
+	Driver      OutputDriver
+	Output      int           //lamp ID, solenoid ID or PCA9685 channel
+	Pulse       time.Duration //on time of a single flash. 0 uses the default (30ms)
+	MinInterval time.Duration //flashes sooner than this after the last one are dropped
+
+	mu        sync.Mutex
+	lastFlash time.Time
+}
+
+var (
+	flashers   []*Flasher
+	flashersMu sync.Mutex
+)
+
+// RegisterFlasher adds a flasher so that it can be flashed by name
+func RegisterFlasher(f *Flasher) {
+	flashersMu.Lock()
+	defer flashersMu.Unlock()
+	flashers = append(flashers, f)
+}
+
+// GetFlasher returns the registered flasher with the name passed in, nil if there isn't one
+func GetFlasher(name string) *Flasher {
+	flashersMu.Lock()
+	defer flashersMu.Unlock()
+
+	for _, f := range flashers {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// Flash flashes the named flasher once
+func Flash(name string) error {
+	f := GetFlasher(name)
+	if f == nil {
+		return fmt.Errorf("unknown flasher %s", name)
+	}
+	f.Flash()
+	return nil
+}
+
+// FlashPattern plays the pattern on the named flasher, one character every step, repeated the number of times passed in (0 for forever)
+func FlashPattern(name string, pattern string, step time.Duration, repeat int) error {
+	f := GetFlasher(name)
+	if f == nil {
+		return fmt.Errorf("unknown flasher %s", name)
+	}
+	if len(pattern) == 0 {
+		return fmt.Errorf("flasher %s: empty pattern", name)
+	}
+
+	pos := 0
+	played := 0
+	Every(f.patternTimer(), ScopeMachine, step, func() {
+		if pattern[pos] == '1' {
+			f.Flash()
+		}
+
+		pos++
+		if pos < len(pattern) {
+			return
+		}
+		pos = 0
+		played++
+		if repeat > 0 && played >= repeat {
+			CancelTimer(f.patternTimer())
+		}
+	})
+	return nil
+}
+
+// StopFlasher stops the pattern playing on the named flasher
+func StopFlasher(name string) {
+	if f := GetFlasher(name); f != nil {
+		CancelTimer(f.patternTimer())
+	}
+}
+
+// Flash pulses the flasher, unless it was flashed less than MinInterval ago
+func (f *Flasher) Flash() {
+	f.mu.Lock()
+	if f.MinInterval > 0 && time.Since(f.lastFlash) < f.MinInterval {
+		f.mu.Unlock()
+		log.Debugf("flashers: %s flashed too soon, dropped", f.Name)
+		return
+	}
+	f.lastFlash = time.Now()
+	f.mu.Unlock()
+
+	pulse := f.Pulse
+	if pulse <= 0 {
+		pulse = defaultFlashPulse
+	}
+
+	if f.Driver == DriverCoil {
+		SolenoidPulse(f.Output, pulse)
+		return
+	}
+
+	setOutput(f.Driver, f.Output, giFull)
+	Delay(ScopeMachine, pulse, func() {
+		setOutput(f.Driver, f.Output, 0)
+	})
+}
+
+func (f *Flasher) patternTimer() string {
+	return "flasher:" + f.Name + ":pattern"
+}
@@ -0,0 +1,366 @@
+package goflip
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+flippers lets each flipper be set up on its own: its button, main (power) coil, hold
+coil and end of stroke (EOS) switch. A flipper with no HoldCoil is a single wound coil,
+held with the HoldDuty of the main coil's CoilProfile. NewFlipper starts a flipper
+with no hold coil or EOS switch (NoCoil and NoSwitch), as 0 is a real coil and switch.
+
+Flippers are normally run by the SDU, and FlipperControl still turns all of them on
+and off at once. With SDUFlipperEnable set, EnableFlipper and DisableFlipper turn single
+flippers on and off on the SDU as well; without it they return an error, as the SDU
+can't be told. A flipper with SoftwareControl is run by goflip instead, for boards
+that don't handle flippers: the main coil is powered when the
+button is pressed, dropped to the hold when the EOS switch closes (or after the main
+coil's Pulse time with no EOS switch), and powered again if the ball knocks the flipper
+off the EOS while the button is held.
+
+Whoever is running them, goflip counts the button presses and checks that the EOS
+switch closes within EOSTimeout of the button being pressed. A missed EOS is counted,
+logged and passed to FlipperObservers. All flippers are turned off on a tilt and at
+game over.
+*/
+
+const (
+	defaultEOSTimeout = 50 * time.Millisecond
+	sduFlipperEnable  = 0xf9 //SDU extended message: flipper enable, main coil, 0 or 1
+)
+
+// FlipperObserver can optionally be implemented by an Observer to be told when a flipper's EOS switch didn't close
+type FlipperObserver interface {
+	FlipperEOSFailed(name string)
+}
+
+// Flipper is a single flipper
+type Flipper struct {
+	Name            string
+	ButtonSwitch    int
+	MainCoil        int
+	HoldCoil        int           //NoCoil for a single wound coil, held at the main coil's HoldDuty
+	EOSSwitch       int           //NoSwitch if there isn't one
+	EOSTimeout      time.Duration //time after the button is pressed that the EOS should close. 0 for the default (50ms)
+	SoftwareControl bool          //goflip fires the coils, for boards that don't handle the flippers
+
+	mu          sync.Mutex
+	enabled     bool
+	held        bool //button is held down
+	powered     bool //main coil is on
+	presses     int
+	eosFailures int
+}
+
+// FlipperStats are the usage counts for a flipper
+type FlipperStats struct {
+	Name        string
+	Enabled     bool
+	Presses     int
+	EOSFailures int
+}
+
+var (
+	flippers   []*Flipper
+	flippersMu sync.Mutex
+)
+
+// NewFlipper returns a single wound flipper with no EOS switch. Set HoldCoil and EOSSwitch if it has them
+func NewFlipper(name string, buttonSwitch int, mainCoil int) *Flipper {
+	return &Flipper{
+		Name:         name,
+		ButtonSwitch: buttonSwitch,
+		MainCoil:     mainCoil,
+		HoldCoil:     NoCoil,
+		EOSSwitch:    NoSwitch,
+	}
+}
+
+// RegisterFlipper adds a flipper. It starts disabled. A single wound flipper run by goflip is held at
+// the HoldDuty of the main coil's CoilProfile, so the profile has to be set first (1-99), and the SDU
+// has to be able to hold it (SDUCoilHold)
+func RegisterFlipper(f *Flipper) error {
+	if f.SoftwareControl && f.HoldCoil == NoCoil {
+		if duty := GetCoilProfile(f.MainCoil).HoldDuty; duty < 1 || duty > 99 {
+			return fmt.Errorf("flipper %s: main coil %d needs a HoldDuty of 1-99 to be held, not %d", f.Name, f.MainCoil, duty)
+		}
+		if !GetMachine().SDUCoilHold {
+			return fmt.Errorf("flipper %s: a single wound flipper needs SDUCoilHold to be held", f.Name)
+		}
+	}
+
+	if f.SoftwareControl {
+		//the hold has to stay on for as long as the button is held
+		if f.HoldCoil == NoCoil {
+			setCoilNoOnLimit(f.MainCoil)
+		} else {
+			setCoilNoOnLimit(f.HoldCoil)
+		}
+	}
+
+	flippersMu.Lock()
+	defer flippersMu.Unlock()
+	flippers = append(flippers, f)
+	return nil
+}
+
+// GetFlipper returns the registered flipper with the name passed in, nil if there isn't one
+func GetFlipper(name string) *Flipper {
+	flippersMu.Lock()
+	defer flippersMu.Unlock()
+
+	for _, f := range flippers {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// EnableFlipper turns the named flipper on
+func EnableFlipper(name string) error {
+	f := GetFlipper(name)
+	if f == nil {
+		return fmt.Errorf("unknown flipper %s", name)
+	}
+	return f.SetEnabled(true)
+}
+
+// DisableFlipper turns the named flipper off
+func DisableFlipper(name string) error {
+	f := GetFlipper(name)
+	if f == nil {
+		return fmt.Errorf("unknown flipper %s", name)
+	}
+	return f.SetEnabled(false)
+}
+
+// FlipperControl turns all of the flippers on or off
+func FlipperControl(on bool) {
+	var msg deviceMessage
+	msg.id = 0x0f
+	if on {
+		msg.value = 0x03
+	} else {
+		msg.value = 0x02
+	}
+
+	sendSolenoid(msg)
+
+	for _, f := range registeredFlippers() {
+		f.setEnabled(on, false)
+	}
+}
+
+// Flippers returns the usage counts for all of the registered flippers
+func Flippers() []FlipperStats {
+	var stats []FlipperStats
+	for _, f := range registeredFlippers() {
+		stats = append(stats, f.Stats())
+	}
+	return stats
+}
+
+// SetEnabled turns the flipper on or off. Flippers run by the SDU can only be turned on and off
+// on their own with SDUFlipperEnable, use FlipperControl otherwise
+func (f *Flipper) SetEnabled(on bool) error {
+	if !f.SoftwareControl && !GetMachine().SDUFlipperEnable {
+		return fmt.Errorf("flipper %s is run by the SDU, and SDUFlipperEnable is not set", f.Name)
+	}
+	f.setEnabled(on, !f.SoftwareControl)
+	return nil
+}
+
+// IsEnabled returns true if the flipper is on
+func (f *Flipper) IsEnabled() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.enabled
+}
+
+// Stats returns the usage counts for the flipper
+func (f *Flipper) Stats() FlipperStats {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return FlipperStats{Name: f.Name, Enabled: f.enabled, Presses: f.presses, EOSFailures: f.eosFailures}
+}
+
+func (f *Flipper) setEnabled(on bool, toSDU bool) {
+	f.mu.Lock()
+	f.enabled = on
+	f.mu.Unlock()
+
+	if toSDU {
+		enable := 0
+		if on {
+			enable = 1
+		}
+		sendSolenoid(deviceMessage{raw: []byte{sduFlipperEnable, byte(f.MainCoil), byte(enable)}})
+	}
+
+	if !on && f.SoftwareControl {
+		f.release()
+	}
+}
+
+func (f *Flipper) eosTimer() string {
+	return "flipper:" + f.Name + ":eos"
+}
+
+func (f *Flipper) powerTimer() string {
+	return "flipper:" + f.Name + ":power"
+}
+
+func (f *Flipper) press() {
+	f.mu.Lock()
+	if !f.enabled || f.held {
+		f.mu.Unlock()
+		return
+	}
+	f.held = true
+	f.presses++
+	f.mu.Unlock()
+
+	if f.EOSSwitch != NoSwitch {
+		timeout := f.EOSTimeout
+		if timeout <= 0 {
+			timeout = defaultEOSTimeout
+		}
+		After(f.eosTimer(), ScopeMachine, timeout, f.checkEOS)
+	}
+
+	if f.SoftwareControl {
+		f.power()
+	}
+}
+
+func (f *Flipper) release() {
+	CancelTimer(f.eosTimer())
+	CancelTimer(f.powerTimer())
+
+	f.mu.Lock()
+	wasHeld := f.held
+	f.held = false
+	f.powered = false
+	f.mu.Unlock()
+
+	if f.SoftwareControl && wasHeld {
+		SolenoidOff(f.MainCoil)
+		if f.HoldCoil != NoCoil {
+			SolenoidOff(f.HoldCoil)
+		}
+	}
+}
+
+// power turns the main coil on, until the EOS switch closes or the main coil's pulse time is up
+func (f *Flipper) power() {
+	f.mu.Lock()
+	if f.powered {
+		f.mu.Unlock()
+		return
+	}
+	f.powered = true
+	f.mu.Unlock()
+
+	SolenoidAlwaysOn(f.MainCoil)
+	if f.HoldCoil != NoCoil {
+		SolenoidAlwaysOn(f.HoldCoil)
+	}
+
+	if f.EOSSwitch == NoSwitch || !SwitchPressed(f.EOSSwitch) {
+		After(f.powerTimer(), ScopeMachine, GetCoilProfile(f.MainCoil).Pulse, f.hold)
+		return
+	}
+	f.hold()
+}
+
+// hold drops the main coil to the hold
+func (f *Flipper) hold() {
+	CancelTimer(f.powerTimer())
+
+	f.mu.Lock()
+	if !f.held || !f.powered {
+		f.mu.Unlock()
+		return
+	}
+	f.powered = false
+	f.mu.Unlock()
+
+	if f.HoldCoil == NoCoil {
+		SolenoidHold(f.MainCoil)
+	} else {
+		SolenoidOff(f.MainCoil)
+	}
+}
+
+func (f *Flipper) checkEOS() {
+	f.mu.Lock()
+	if !f.held || SwitchPressed(f.EOSSwitch) {
+		f.mu.Unlock()
+		return
+	}
+	f.eosFailures++
+	f.mu.Unlock()
+
+	log.Warnf("flippers: %s EOS switch did not close", f.Name)
+
+	g := GetMachine()
+	for _, o := range g.Observers {
+		if fo, ok := o.(FlipperObserver); ok {
+			fo.FlipperEOSFailed(f.Name)
+		}
+	}
+}
+
+func (f *Flipper) switchHandler(sw SwitchEvent) {
+	switch sw.SwitchID {
+	case f.ButtonSwitch:
+		if sw.Pressed {
+			f.press()
+		} else {
+			f.release()
+		}
+	case f.EOSSwitch:
+		if !f.SoftwareControl {
+			return
+		}
+		f.mu.Lock()
+		held := f.held
+		f.mu.Unlock()
+
+		if sw.Pressed {
+			f.hold()
+		} else if held {
+			//knocked off the EOS by the ball, power it back up
+			f.power()
+		}
+	}
+}
+
+func registeredFlippers() []*Flipper {
+	flippersMu.Lock()
+	defer flippersMu.Unlock()
+	return append([]*Flipper(nil), flippers...)
+}
+
+// flipperSwitch is called from the switch read loop for every switch event
+func flipperSwitch(sw SwitchEvent) {
+	for _, f := range registeredFlippers() {
+		f.switchHandler(sw)
+	}
+}
+
+// flipperStateChange is called on every state transition to turn the flippers off on a tilt and at game over
+func flipperStateChange(to MachineState) {
+	switch to {
+	case StateTilted, StateGameOver:
+		if len(registeredFlippers()) > 0 {
+			FlipperControl(false)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package goflip
+
+import (
+	"testing"
+	"time"
+)
+
+// testSolenoids gives the solenoid messages somewhere to go without a SolenoidSubscriber, and returns a func that
+// takes the messages sent so far
+func testSolenoids(t *testing.T) func() []deviceMessage {
+	saved := solenoidControl
+	solenoidControl = make(chan deviceMessage, 100)
+	t.Cleanup(func() { solenoidControl = saved })
+
+	g := GetMachine()
+	if g.switchStates == nil {
+		g.switchStates = make([]bool, 64)
+	}
+
+	return func() []deviceMessage {
+		var sent []deviceMessage
+		for {
+			select {
+			case msg := <-solenoidControl:
+				sent = append(sent, msg)
+			default:
+				return sent
+			}
+		}
+	}
+}
+
+// flipSwitch sets the switch the way the switch read loop does, and passes it to the flipper
+func flipSwitch(f *Flipper, sw int, pressed bool) {
+	GetMachine().switchStates[sw] = pressed
+	f.switchHandler(SwitchEvent{SwitchID: sw, Pressed: pressed})
+}
+
+func TestRegisterFlipper(t *testing.T) {
+	testSolenoids(t)
+	g := GetMachine()
+	saved := g.SDUCoilHold
+	defer func() { g.SDUCoilHold = saved }()
+
+	SetCoilProfile(20, CoilProfile{HoldDuty: 30})
+	SetCoilProfile(21, CoilProfile{HoldDuty: 100})
+
+	tests := []struct {
+		name     string
+		flipper  *Flipper
+		coilHold bool
+		wantErr  bool
+	}{
+		{"SDU flipper on coil 0 and switch 0", &Flipper{Name: "sdu", ButtonSwitch: 1, MainCoil: 0, HoldCoil: 0, EOSSwitch: 0}, false, false},
+		{"software with a hold coil", &Flipper{Name: "sw", ButtonSwitch: 1, MainCoil: 22, HoldCoil: 23, EOSSwitch: NoSwitch, SoftwareControl: true}, false, false},
+		{"software single wound", &Flipper{Name: "single", ButtonSwitch: 1, MainCoil: 20, HoldCoil: NoCoil, EOSSwitch: NoSwitch, SoftwareControl: true}, true, false},
+		{"software single wound without SDUCoilHold", &Flipper{Name: "nohold", ButtonSwitch: 1, MainCoil: 20, HoldCoil: NoCoil, EOSSwitch: NoSwitch, SoftwareControl: true}, false, true},
+		{"software single wound with no hold duty", &Flipper{Name: "noduty", ButtonSwitch: 1, MainCoil: 24, HoldCoil: NoCoil, EOSSwitch: NoSwitch, SoftwareControl: true}, true, true},
+		{"software single wound held full", &Flipper{Name: "full", ButtonSwitch: 1, MainCoil: 21, HoldCoil: NoCoil, EOSSwitch: NoSwitch, SoftwareControl: true}, true, true},
+		{"NewFlipper defaults to single wound", NewFlipper("new", 1, 24), false, false},
+		{"NewFlipper software without a hold duty", func() *Flipper { f := NewFlipper("newsw", 1, 24); f.SoftwareControl = true; return f }(), true, true},
+	}
+
+	for _, tt := range tests {
+		g.SDUCoilHold = tt.coilHold
+		err := RegisterFlipper(tt.flipper)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: RegisterFlipper() error = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestFlipperSetEnabled(t *testing.T) {
+	testSolenoids(t)
+	g := GetMachine()
+	saved := g.SDUFlipperEnable
+	defer func() { g.SDUFlipperEnable = saved }()
+
+	sdu := NewFlipper("setEnabled", 1, 25)
+	soft := &Flipper{Name: "setEnabledSoft", ButtonSwitch: 2, MainCoil: 26, HoldCoil: 27, EOSSwitch: NoSwitch, SoftwareControl: true}
+
+	tests := []struct {
+		name       string
+		flipper    *Flipper
+		sduEnable  bool
+		wantErr    bool
+		wantEnable bool
+	}{
+		{"SDU flipper without SDUFlipperEnable", sdu, false, true, false},
+		{"SDU flipper with SDUFlipperEnable", sdu, true, false, true},
+		{"software flipper", soft, false, false, true},
+	}
+
+	for _, tt := range tests {
+		g.SDUFlipperEnable = tt.sduEnable
+		tt.flipper.setEnabled(false, false)
+		err := tt.flipper.SetEnabled(true)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: SetEnabled() error = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		if tt.flipper.IsEnabled() != tt.wantEnable {
+			t.Errorf("%s: IsEnabled() = %v, want %v", tt.name, tt.flipper.IsEnabled(), tt.wantEnable)
+		}
+	}
+}
+
+func TestSoftwareFlipper(t *testing.T) {
+	taken := testSolenoids(t)
+
+	const (
+		button = 10
+		eos    = 11
+		main   = 12
+		hold   = 13
+	)
+
+	type step struct {
+		sw      int
+		pressed bool
+		want    []deviceMessage
+	}
+
+	on := func(coil int) deviceMessage { return deviceMessage{id: coil, value: sduHoldOn} }
+	off := func(coil int) deviceMessage { return deviceMessage{id: coil, value: Off} }
+
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"press, EOS, release", []step{
+			{button, true, []deviceMessage{on(main), on(hold)}},
+			{eos, true, []deviceMessage{off(main)}},
+			{button, false, []deviceMessage{off(main), off(hold)}},
+			{eos, false, nil},
+		}},
+		{"knocked off the EOS", []step{
+			{button, true, []deviceMessage{on(main), on(hold)}},
+			{eos, true, []deviceMessage{off(main)}},
+			{eos, false, []deviceMessage{on(main), on(hold)}},
+			{eos, true, []deviceMessage{off(main)}},
+			{button, false, []deviceMessage{off(main), off(hold)}},
+			{eos, false, nil},
+		}},
+		{"EOS already closed", []step{
+			{eos, true, nil},
+			{button, true, []deviceMessage{on(main), on(hold), off(main)}},
+			{button, false, []deviceMessage{off(main), off(hold)}},
+			{eos, false, nil},
+		}},
+		{"EOS ignored with the button up", []step{
+			{eos, true, nil},
+			{eos, false, nil},
+		}},
+	}
+
+	for _, tt := range tests {
+		f := &Flipper{Name: "soft", ButtonSwitch: button, MainCoil: main, HoldCoil: hold, EOSSwitch: eos, SoftwareControl: true}
+		f.setEnabled(true, false)
+
+		for i, s := range tt.steps {
+			flipSwitch(f, s.sw, s.pressed)
+			got := taken()
+			if len(got) != len(s.want) {
+				t.Errorf("%s step %d: sent %v, want %v", tt.name, i+1, got, s.want)
+				continue
+			}
+			for j := range got {
+				if got[j].id != s.want[j].id || got[j].value != s.want[j].value || len(got[j].raw) > 0 {
+					t.Errorf("%s step %d: sent %v, want %v", tt.name, i+1, got, s.want)
+					break
+				}
+			}
+		}
+		f.setEnabled(false, false)
+		CancelTimer(f.eosTimer())
+		CancelTimer(f.powerTimer())
+		taken()
+	}
+}
+
+func TestSingleWoundFlipperHold(t *testing.T) {
+	taken := testSolenoids(t)
+	g := GetMachine()
+	saved := g.SDUCoilHold
+	defer func() { g.SDUCoilHold = saved }()
+	g.SDUCoilHold = true
+
+	const main = 14
+	SetCoilProfile(main, CoilProfile{Pulse: 20 * time.Millisecond, HoldDuty: 25})
+
+	f := NewFlipper("single", 15, main)
+	f.SoftwareControl = true
+	if err := RegisterFlipper(f); err != nil {
+		t.Fatal(err)
+	}
+	f.setEnabled(true, false)
+	defer f.setEnabled(false, false)
+
+	flipSwitch(f, f.ButtonSwitch, true)
+	if got := taken(); len(got) != 1 || got[0].id != main || got[0].value != sduHoldOn {
+		t.Fatalf("press sent %v, want the main coil on", got)
+	}
+
+	//no EOS switch, so it drops to the hold after the main coil's pulse time
+	time.Sleep(60 * time.Millisecond)
+	got := taken()
+	if len(got) != 1 || len(got[0].raw) != 3 || got[0].raw[0] != sduCoilHold || got[0].raw[1] != main || got[0].raw[2] != 25 {
+		t.Fatalf("after the pulse sent %v, want a hold of coil %d at 25%%", got, main)
+	}
+
+	flipSwitch(f, f.ButtonSwitch, false)
+	if got := taken(); len(got) != 1 || got[0].id != main || got[0].value != Off {
+		t.Errorf("release sent %v, want the main coil off", got)
+	}
+}
+
+func TestFlipperEOSFailures(t *testing.T) {
+	taken := testSolenoids(t)
+
+	const (
+		button = 16
+		eos    = 17
+	)
+
+	tests := []struct {
+		name         string
+		enabled      bool
+		eosCloses    bool
+		wantPresses  int
+		wantFailures int
+	}{
+		{"EOS closes", true, true, 1, 0},
+		{"EOS missed", true, false, 1, 1},
+		{"disabled", false, false, 0, 0},
+	}
+
+	for _, tt := range tests {
+		f := NewFlipper("eos", button, 18)
+		f.EOSSwitch = eos
+		f.EOSTimeout = 10 * time.Millisecond
+		f.setEnabled(tt.enabled, false)
+
+		flipSwitch(f, button, true)
+		if tt.eosCloses {
+			flipSwitch(f, eos, true)
+		}
+		time.Sleep(40 * time.Millisecond)
+		flipSwitch(f, button, false)
+		flipSwitch(f, eos, false)
+
+		stats := f.Stats()
+		if stats.Presses != tt.wantPresses || stats.EOSFailures != tt.wantFailures {
+			t.Errorf("%s: %d presses and %d EOS failures, want %d and %d", tt.name, stats.Presses, stats.EOSFailures, tt.wantPresses, tt.wantFailures)
+		}
+		if got := taken(); len(got) > 0 {
+			t.Errorf("%s: SDU flipper sent %v, want nothing", tt.name, got)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package goflip
+
+import (
+	"errors"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+gameControl handles getting out of a game before it is played through. Holding the
+start button for RestartConfig.HoldTime aborts the game in progress and starts a new
+one. AbortGame can also be called from the game or the web interface. An aborted game
+goes through GameOver for the Observers, but it is not treated as a completed game
+(no match, and the scores are not recorded).
+*/
+
+// RestartConfig holds the operator settings for restarting a game with the start button
+type RestartConfig struct {
+	Enabled     bool
+	StartSwitch int           //switch ID of the start button. NoSwitch if not used
+	HoldTime    time.Duration //how long start must be held to restart the game
+}
+
+// ErrNoGameInProgress is returned when a game is needed but none is going on
+var ErrNoGameInProgress = errors.New("no game in progress")
+
+var startHoldTimer *time.Timer
+
+// IsGameInProgress returns true if the machine is in any of the states of a game being played
+func IsGameInProgress() bool {
+	switch GetState() {
+	case StateGameStarted, StateBallStarting, StateBallInPlay, StateTilted, StateBallEnding, StateBonus:
+		return true
+	}
+	return false
+}
+
+// AbortGame ends the game in progress right away. Observers are sent GameOver, but the game is not recorded
+func AbortGame() error {
+	g := GetMachine()
+
+	if !IsGameInProgress() {
+		return ErrNoGameInProgress
+	}
+
+	log.Infoln("gameControl: aborting game")
+	g.gameAborted = true
+	return ChangeState(StateGameOver)
+}
+
+// StartNewGame starts a game with one player, the same as pressing start from attract mode
+func StartNewGame() error {
+	if err := ChangeState(StateGameStarted); err != nil {
+		return err
+	}
+
+	AddPlayer()
+	return ChangeState(StateBallStarting)
+}
+
+// RestartGame aborts the game in progress and starts a new one
+func RestartGame() error {
+	if err := AbortGame(); err != nil {
+		return err
+	}
+	return StartNewGame()
+}
+
+// startButtonSwitch is called for every switch event to watch for start being held during a game
+func startButtonSwitch(sw SwitchEvent) {
+	g := GetMachine()
+	cfg := g.RestartConfig
+
+	if !cfg.Enabled || sw.SwitchID != cfg.StartSwitch {
+		return
+	}
+
+	if startHoldTimer != nil {
+		startHoldTimer.Stop()
+		startHoldTimer = nil
+	}
+
+	if !sw.Pressed || !IsGameInProgress() {
+		return
+	}
+
+	startHoldTimer = time.AfterFunc(cfg.HoldTime, func() {
+		if !SwitchPressed(cfg.StartSwitch) || !IsGameInProgress() {
+			return
+		}
+
+		log.Infoln("gameControl: start held, restarting game")
+		if err := RestartGame(); err != nil {
+			log.Errorf("gameControl: unable to restart game: %v", err)
+		}
+	})
+}
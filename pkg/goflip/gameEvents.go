@@ -13,13 +13,16 @@ Player Control events:
 GameStart = called when a credit is added to the machine (someone presses the credit button)
 AddPlayer = adds to the number of players in the game
 PlayerUp = called every time that a new player is up
-PlayerEnd = called every time a player loses the ball in play
+PlayerEnd = called every time a player loses the ball in play (after the bonus is counted)
 PlayerFinish = called at the very end of the game for that player
 GameOver = called at the very end of the game
 
 BallInPlay events:
 BallDrained = called when a ball is now found in the outhole
-BallInPlay = called when a ball is launched
+BallLaunched = called when a ball is launched
+Tilt = called by the game when the ball in play is tilted
+
+These are called by the state machine (see stateMachine.go) as the machine changes state.
 */
 
 // GameStart is called when a game is started (when the first player gets a credit)
@@ -36,7 +39,18 @@ func GameStart() {
 	g.BallInPlay = 0 //nextup will queue this up
 	g.NumOfPlayers = 0
 	g.CurrentPlayer = 0
+	g.ExtraBall = false
+	g.gameAborted = false
+	g.gameNumber++
+	g.matchValue = 0
+	g.Tilted = false
+	ClearBonus()
 	ClearScores()
+	resetPlayerVars()
+	resetReplays()
+	resetTargets()
+	resetScoring()
+	showCredits()
 
 	for _, f := range g.Observers {
 		f.GameStart()
@@ -61,7 +75,28 @@ func GameOver() {
 		f.GameOver()
 	}
 
-	ChangePlayerState(NoPlayer)
+	if g.gameAborted {
+		log.Debugln("gameEvents:GameOver() game was aborted, not recorded")
+		return
+	}
+
+	recordReplayScores()
+	recordLastScores()
+	checkHighScores()
+
+	//the match runs for a few seconds, so it gets its own copy of the final scores
+	scores := make([]int64, g.NumOfPlayers)
+	for p := range scores {
+		scores[p] = PlayerScore(p + 1)
+	}
+	go runMatch(scores)
+}
+
+// Tilt moves the machine to the Tilted state. The ball in play still needs to drain (ChangePlayerState(EndPlayer))
+// for the ball to end, but no bonus is counted.
+func Tilt() {
+	log.Debugln("Tilt() called")
+	changeState(StateTilted)
 }
 
 func BallDrained() {
@@ -92,6 +127,8 @@ func PlayerFinish() {
 	}
 }
 
+// PlayerEnd tells the Observers that the player's ball is over. This blocks until all of the
+// observers are done with their PlayerEnd work.
 func PlayerEnd() {
 	g := GetMachine()
 	if g.TestMode {
@@ -105,17 +142,14 @@ func PlayerEnd() {
 		f.PlayerEnd(g.CurrentPlayer, &wait)
 	}
 
-	go func() {
-		wait.Wait() //need to wait for all observers to be done with any goroutines.
-		ChangePlayerState(UpPlayer)
-	}()
+	wait.Wait() //need to wait for all observers to be done with any goroutines.
 }
 
 func PlayerUp() {
 	g := GetMachine()
 
-	if g.gameState != InProgress {
-		log.Warnln("PlayerUp called, but game is not started")
+	if GetState() != StateBallStarting {
+		log.Warnln("PlayerUp called, but no ball is starting")
 		return
 	}
 
@@ -126,6 +160,13 @@ func PlayerUp() {
 	}
 
 	g.BallScore = 0 //reset before any points are added
+	g.Tilted = false
+	g.ballLaunched = false
+	SetPlayfieldMultiplier(1)
+	ClearBonus()
+	endSkillShot()
+
+	previousPlayer := g.CurrentPlayer
 
 	if g.BallInPlay == 0 {
 		//first time we are playing
@@ -133,7 +174,12 @@ func PlayerUp() {
 		g.CurrentPlayer = 1
 	}
 
-	if g.CurrentPlayer < g.NumOfPlayers {
+	shootAgain := g.ExtraBall && g.BallInPlay > 0
+	if shootAgain {
+		//same player and ball
+		g.ExtraBall = false
+		log.Debugf("PlayerUp: player %d shoots again", g.CurrentPlayer)
+	} else if g.CurrentPlayer < g.NumOfPlayers {
 		g.CurrentPlayer++ //we are staying on the same ball
 	} else {
 		//next ball
@@ -142,45 +188,59 @@ func PlayerUp() {
 			g.CurrentPlayer = 1
 		} else {
 			//game over
-			ChangeGameState(GameEnded)
+			changeState(StateGameOver)
 			return
 		}
 	}
 
+	if g.CurrentPlayer != previousPlayer {
+		CancelScope(ScopePlayer)
+	}
+
 	SetBallInPlayDisp(int8(g.BallInPlay))
 
-	if g.BallInPlay == 1 {
+	if g.BallInPlay == 1 && !shootAgain {
 		for _, f := range g.Observers {
 			f.PlayerStart(g.CurrentPlayer)
 		}
 	}
 
+	CurrentPlayerVars().RestoreLamps(g.PlayerLamps...)
+	armSkillShot()
+
 	for _, f := range g.Observers {
 		f.PlayerUp(g.CurrentPlayer)
 	}
 }
 
-func AddPlayer() {
+// AddPlayer adds a player to the game in progress. Players can only be added while on ball 1.
+// Returns true if the player was added.
+func AddPlayer() bool {
 	log.Debugln("AddPlayer() called")
 	g := GetMachine()
 
-	if g.TestMode {
-		return
+	if g.TestMode || !IsGameInProgress() {
+		return false
 	}
 
 	//sanity check, only can add a player if on ball 1
 	if g.BallInPlay > 1 {
-		return
+		return false
+	}
+
+	if g.NumOfPlayers >= g.MaxPlayers {
+		return false
 	}
 
-	if g.NumOfPlayers < g.MaxPlayers {
-		g.NumOfPlayers++
+	g.NumOfPlayers++
 
+	if g.NumOfPlayers <= maxScoreDisplays {
 		ShowDisplay(g.NumOfPlayers, true)
-		log.Debugf("ShowDisplay was called passing: %d, true", g.NumOfPlayers)
+	}
+	log.Debugf("ShowDisplay was called passing: %d, true", g.NumOfPlayers)
 
-		for _, f := range g.Observers {
-			f.PlayerAdded(g.NumOfPlayers)
-		}
+	for _, f := range g.Observers {
+		f.PlayerAdded(g.NumOfPlayers)
 	}
+	return true
 }
@@ -0,0 +1,229 @@
+package goflip
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+gi controls the general illumination strings. Each string is driven by an LDU lamp
+output, an SDU solenoid output or a PCA9685 channel (the board the servo is on). Only
+PCA9685 strings can be dimmed; the other outputs are on for any brightness above 0.
+
+The GI follows the machine state: it is set to GIConfig.AttractBrightness in attract
+mode, turned off when the ball is tilted (GIConfig.TiltOff), and back to full when the
+next ball starts. Games can set the strings at any other time (GIFlicker for multiball
+for example).
+*/
+
+// OutputDriver is the board an output is wired to
+type OutputDriver int
+
+const (
+	DriverLamp OutputDriver = iota //LDU lamp output
+	DriverCoil                     //SDU solenoid output
+	DriverPWM                      //PCA9685 channel
+)
+
+const (
+	giFull       = 100
+	giFlickerMin = 40 * time.Millisecond
+)
+
+// GIConfig holds the settings for how the GI follows the machine state
+type GIConfig struct {
+	AttractBrightness int  //0-100 percent
+	TiltOff           bool //turn the GI off when the ball is tilted
+}
+
+// GIString is a general illumination circuit
+type GIString struct {
+	Name   string
+	Driver OutputDriver
+	Output int //lamp ID, solenoid ID or PCA9685 channel
+
+	mu         sync.Mutex
+	brightness int
+}
+
+var (
+	giStrings   []*GIString
+	giStringsMu sync.Mutex
+)
+
+// RegisterGIString adds a GI string so that it can be controlled by name
+func RegisterGIString(s *GIString) {
+	if s.Driver == DriverCoil {
+		setCoilNoOnLimit(s.Output)
+	}
+
+	giStringsMu.Lock()
+	defer giStringsMu.Unlock()
+	giStrings = append(giStrings, s)
+}
+
+// GetGIString returns the registered GI string with the name passed in, nil if there isn't one
+func GetGIString(name string) *GIString {
+	giStringsMu.Lock()
+	defer giStringsMu.Unlock()
+
+	for _, s := range giStrings {
+		if s.Name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// SetGI sets the brightness (0-100 percent) of the named GI strings. No names sets all of them
+func SetGI(brightness int, names ...string) error {
+	strs, err := giLookup(names)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range strs {
+		CancelTimer(s.flickerTimer())
+		CancelTimer(s.flickerEndTimer())
+		s.SetBrightness(brightness)
+	}
+	return nil
+}
+
+// GIOn turns the named GI strings on full. No names turns all of them on
+func GIOn(names ...string) error {
+	return SetGI(giFull, names...)
+}
+
+// GIOff turns the named GI strings off. No names turns all of them off
+func GIOff(names ...string) error {
+	return SetGI(0, names...)
+}
+
+// GIFlicker flickers the named GI strings for d, then puts them back to the brightness they were at
+func GIFlicker(d time.Duration, names ...string) error {
+	strs, err := giLookup(names)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range strs {
+		s := s
+		restore := s.Brightness()
+		on := false
+
+		Every(s.flickerTimer(), ScopeMachine, giFlickerMin, func() {
+			//skip some of the steps so the flicker doesn't look even
+			if rand.Intn(3) == 0 {
+				return
+			}
+			on = !on
+			if on {
+				s.setOutput(restore)
+			} else {
+				s.setOutput(0)
+			}
+		})
+
+		After(s.flickerEndTimer(), ScopeMachine, d, func() {
+			CancelTimer(s.flickerTimer())
+			s.setOutput(restore)
+		})
+	}
+	return nil
+}
+
+// Brightness returns the brightness the string was last set to
+func (s *GIString) Brightness() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.brightness
+}
+
+// SetBrightness sets the string to the brightness, 0-100 percent
+func (s *GIString) SetBrightness(brightness int) {
+	if brightness < 0 {
+		brightness = 0
+	}
+	if brightness > giFull {
+		brightness = giFull
+	}
+
+	s.mu.Lock()
+	s.brightness = brightness
+	s.mu.Unlock()
+
+	s.setOutput(brightness)
+}
+
+func (s *GIString) setOutput(brightness int) {
+	setOutput(s.Driver, s.Output, brightness)
+}
+
+func (s *GIString) flickerTimer() string {
+	return "gi:" + s.Name + ":flicker"
+}
+
+func (s *GIString) flickerEndTimer() string {
+	return "gi:" + s.Name + ":flicker:end"
+}
+
+// setOutput drives a lamp, solenoid or PCA9685 output. Lamp and solenoid outputs are on for any brightness above 0
+func setOutput(driver OutputDriver, output int, brightness int) {
+	switch driver {
+	case DriverLamp:
+		if brightness > 0 {
+			SetLampState(output, On)
+		} else {
+			SetLampState(output, Off)
+		}
+	case DriverCoil:
+		if brightness > 0 {
+			SolenoidAlwaysOn(output)
+		} else {
+			SolenoidOff(output)
+		}
+	case DriverPWM:
+		PWMDuty(output, brightness)
+	default:
+		log.Errorf("gi: unknown output driver %d", driver)
+	}
+}
+
+func giLookup(names []string) ([]*GIString, error) {
+	if len(names) == 0 {
+		giStringsMu.Lock()
+		defer giStringsMu.Unlock()
+		return append([]*GIString(nil), giStrings...), nil
+	}
+
+	strs := make([]*GIString, 0, len(names))
+	for _, n := range names {
+		s := GetGIString(n)
+		if s == nil {
+			return nil, fmt.Errorf("unknown GI string %s", n)
+		}
+		strs = append(strs, s)
+	}
+	return strs, nil
+}
+
+// giStateChange is called on every state transition to set the GI for the new state
+func giStateChange(to MachineState) {
+	g := GetMachine()
+
+	switch to {
+	case StateAttract:
+		SetGI(g.GIConfig.AttractBrightness)
+	case StateGameStarted, StateBallStarting, StateService:
+		SetGI(giFull)
+	case StateTilted:
+		if g.GIConfig.TiltOff {
+			SetGI(0)
+		}
+	}
+}
@@ -18,7 +18,7 @@ var pWMControl chan pwmMessage
 
 type GoFlip struct {
 	devices        arduinos
-	scores         [4]int32
+	scores         []int64
 	BallInPlay     int //If no ball, then 0. //used
 	ExtraBall      bool
 	TotalBalls     int       //used
@@ -27,12 +27,14 @@ type GoFlip struct {
 	NumOfPlayers   int       //number of players playing
 	PWMPortConfig  PWMConfig //used
 	switchStates   []bool
-	lampStates     map[int]int
+	lampStates     map[int]int //base game lamp layer
+	serviceLamps   map[int]int //service mode lamp layer
+	lampMu         sync.Mutex
 	Observers      []Observer //used
 	CurrentPlayer  int        //used
 	observerEvents chan SwitchEvent
 	//GameRunning      bool  //Whether a game is going on = true, or game is over = false
-	BallScore        int32    //current score for the ball in play
+	BallScore        int64    //current score for the ball in play
 	TestMode         bool     //states whether we are in Test Mode or not //used
 	DiagObserver     Observer //used
 	playerEndChannel chan bool
@@ -40,6 +42,37 @@ type GoFlip struct {
 	playerState      PState
 	Quitting         bool //Notifies all go routines that the running application is quitting //used
 	ConsoleMode      bool //Signifies that goFlip is being used for running in a console vs an actual machine //used
+	BallSearchConfig BallSearchConfig
+	MatchConfig      MatchConfig
+	KnockerCoil      int //solenoid ID of the knocker, NoCoil if there isn't one
+	MaxCredits       int //most credits the machine will hold. 0 = no limit
+	BonusConfig      BonusConfig
+	Tilted           bool //set by the game when the ball in play is tilted. No bonus is counted for a tilted ball
+	LaunchConfig     LaunchConfig
+	SkillShotConfig  SkillShotConfig
+	PlayerLamps      []int //lamps that are saved and restored for each player between balls
+	ReplayConfig     ReplayConfig
+	DataPath         string //folder that goflip saves its data to (recent scores, high scores..). Empty to not save
+	matchValue       int
+	ballLaunched     bool
+	playerVars       []*PlayerVars
+	playerVarsMu     sync.Mutex
+	replaysEarned    []int
+	replayHistory    replayHistory
+	replayMu         sync.Mutex
+	RestartConfig    RestartConfig
+	ScoreConfig      ScoreConfig
+	AttractConfig    AttractConfig
+	HighScoreConfig  HighScoreConfig
+	LampShowClock    time.Duration //tick of the shared lamp show clock
+	GIConfig         GIConfig
+	SDUAutofire      bool //the SDU firmware runs the autofire rules itself
+	CoilSafetyConfig CoilSafetyConfig
+	SDUFlipperEnable bool //the SDU firmware can turn single flippers on and off
+	SDUCoilHold      bool //the SDU firmware can hold a coil below 100% duty (CoilProfile.HoldDuty)
+	gameAborted      bool
+	gameNumber       int //incremented for every game started
+	lastScores       []int64
 }
 
 type Observer interface {
@@ -51,6 +84,7 @@ type Observer interface {
 	PlayerEnd(int, *sync.WaitGroup) //called after every ball is ended for the player (after ball drain)
 	PlayerFinish(int)               //called after the very last ball for the player is over (after ball 3 for example)
 	SwitchHandler(SwitchEvent)      //called every time a switch event occurs
+	BallLaunched()                  //called when the ball in play leaves the shooter lane
 	BallDrained()                   //calls when a ball is drained
 	GameOver()                      //called when a game is over
 }
@@ -97,7 +131,8 @@ const (
 
 type deviceMessage struct {
 	id    int
-	value int //set to one of the constants
+	value int    //set to one of the constants
+	raw   []byte //sent as is instead of id and value, for the longer messages
 }
 
 // Init is Called just one time in the beginning to Initialize the game
@@ -106,6 +141,7 @@ func (g *GoFlip) Init(m func(SwitchEvent)) bool {
 	go StartServer()
 	g.gameState = Init
 	g.playerState = NoPlayer
+	machineState.current = StateAttract
 
 	log.AddHook(MsgHook{})
 	g.playerEndChannel = make(chan bool)
@@ -126,6 +162,55 @@ func (g *GoFlip) Init(m func(SwitchEvent)) bool {
 	g.switchStates = make([]bool, 64)
 	log.Println("!!!Setting LampStates!!")
 	g.lampStates = make(map[int]int)
+	g.serviceLamps = make(map[int]int)
+	g.KnockerCoil = NoCoil
+	g.MaxCredits = 40
+	g.MatchConfig = MatchConfig{Enabled: true, Percentage: 10}
+	g.ReplayConfig = ReplayConfig{
+		Award:          SpecialCredit,
+		AutoPercentage: 10,
+		AutoGames:      50,
+		AutoLevels:     1,
+		AutoStart:      1000000,
+		AutoRound:      10000,
+	}
+	g.LampShowClock = defaultLampShowClock
+	g.GIConfig = GIConfig{AttractBrightness: giFull, TiltOff: true}
+	g.CoilSafetyConfig = CoilSafetyConfig{
+		Enabled:     false, //set once the holds (flippers, GI, magnets) have NoLimit in their CoilProfile
+		MaxOnTime:   time.Second,
+		MaxDuty:     50,
+		Window:      10 * time.Second,
+		HangTimeout: time.Second,
+	}
+	g.HighScoreConfig = HighScoreConfig{
+		Enabled:        true,
+		Entries:        4,
+		InitialsLength: 3,
+		LeftSwitch:     NoSwitch,
+		RightSwitch:    NoSwitch,
+		SelectSwitch:   NoSwitch,
+		EntryTimeout:   20 * time.Second,
+	}
+	g.AttractConfig = AttractConfig{
+		ScoreCycle:   2 * time.Second,
+		LampStep:     100 * time.Millisecond,
+		RestartDelay: 30 * time.Second,
+	}
+	g.ScoreConfig = ScoreConfig{Rollover: RolloverWrap, DisplayMax: defaultDisplayMax}
+	g.RestartConfig = RestartConfig{StartSwitch: NoSwitch, HoldTime: 2 * time.Second}
+	g.LaunchConfig = LaunchConfig{ShooterLaneSwitch: NoSwitch}
+	g.SkillShotConfig = SkillShotConfig{Window: 3 * time.Second}
+	g.BonusConfig = BonusConfig{
+		StepDelay: 100 * time.Millisecond,
+		ItemDelay: 500 * time.Millisecond,
+	}
+	g.BallSearchConfig = BallSearchConfig{
+		Timeout:    20 * time.Second,
+		PulseDelay: 250 * time.Millisecond,
+		RoundDelay: 2 * time.Second,
+		MaxRounds:  5,
+	}
 
 	gpioInit()
 
@@ -153,8 +238,15 @@ func (g *GoFlip) Init(m func(SwitchEvent)) bool {
 	log.Println("Starting LampSubscriber()")
 
 	go LampSubscriber()
-	go SolenoidSubscriber()
+	startSolenoids()
 	go gpioSubscriber()
+	go ledSubscriber()
+	go coilWatchdog()
+	go ballSearchMonitor()
+
+	sendAutofireRules()
+	loadReplayHistory()
+	loadHighScores()
 
 	for _, f := range g.Observers {
 		f.Init()
@@ -168,6 +260,16 @@ func (g *GoFlip) Init(m func(SwitchEvent)) bool {
 				g.DiagObserver.SwitchHandler(sw)
 
 				if !g.TestMode {
+					attractSwitch(sw)
+					initialsSwitch(sw)
+
+					if g.gameState == InProgress {
+						ballLaunchSwitch(sw)
+						skillShotSwitch(sw)
+						modeSwitchHandler(sw)
+						targetSwitchHandler(sw)
+					}
+
 					//call individual feature Switch Handling too.
 					for _, f := range g.Observers {
 						f.SwitchHandler(sw)
@@ -194,16 +296,25 @@ func (g *GoFlip) Init(m func(SwitchEvent)) bool {
 
 			//we should never receive 0 switch events... so if we do, maybe we stop and reinitialize??
 
+			switchLoopBusy(true)
 			for _, sw := range buf {
 				g.switchStates[sw.SwitchID] = sw.Pressed
+				flipperSwitch(sw)
+				autofireSwitch(sw)
+				ballSearchSwitch(sw)
+				startButtonSwitch(sw)
 				m(sw) //main switch eventHandler called
 
 				g.observerEvents <- sw
 			}
+			switchLoopBusy(false)
 		}
 
 	}()
 
+	//the machine starts out in attract mode, there's no transition into it to start the attract
+	StartAttract()
+
 	return true
 }
 
@@ -218,29 +329,24 @@ func IsGameInPlay() bool {
 	return g.BallInPlay > 0
 }
 
-func AddScore(points int) {
-	g := GetMachine()
-	if g.CurrentPlayer < 1 {
-		return
-	}
-	g.scores[g.CurrentPlayer-1] += int32(points)
-	g.BallScore += int32(points)
-	log.Debugf("goFlip:BallScore = %d, total = %d\n", g.BallScore, g.scores[g.CurrentPlayer-1])
-
-	//refresh display
-	SetDisplay(g.CurrentPlayer, g.scores[g.CurrentPlayer-1])
-}
-
+// ClearScores zeroes the scores for all players (sized to MaxPlayers) and blanks the score displays
 func ClearScores() {
 	g := GetMachine()
+	g.scores = make([]int64, g.MaxPlayers)
 	for i := range g.scores {
-		g.scores[i] = 0
-		ShowDisplay(i+1, false)
+		if i < maxScoreDisplays {
+			ShowDisplay(i+1, false)
+		}
 	}
 }
 
-func PlayerScore(playerNumber int) int32 {
+// PlayerScore returns the score of the player number passed in (1 based). 0 is returned for an invalid player
+func PlayerScore(playerNumber int) int64 {
 	g := GetMachine()
+	if playerNumber < 1 || playerNumber > len(g.scores) {
+		log.Warnf("PlayerScore called for invalid player %d", playerNumber)
+		return 0
+	}
 	return g.scores[playerNumber-1]
 }
 
@@ -248,20 +354,15 @@ func SendStats() {
 	g := GetMachine()
 	stat := GameStats{}
 	stat.BallInPlay = g.BallInPlay
-	stat.Credits = 0
+	stat.Credits = int16(g.Credits)
 	stat.Display1 = 0
 	stat.Display2 = 0
 	stat.Display3 = 0
 	stat.Display4 = 0
-	stat.Match = 0
+	stat.Match = int16(g.matchValue)
 	stat.TotalBalls = g.TotalBalls
-	i := len(g.scores)
-
-	if i > 0 {
-		stat.Player1Score = g.scores[0]
-		stat.Player2Score = g.scores[1]
-		stat.Player3Score = g.scores[2]
-		stat.Player4Score = g.scores[3]
+	if g.NumOfPlayers <= len(g.scores) {
+		stat.Scores = append([]int64(nil), g.scores[:g.NumOfPlayers]...)
 	}
 	statb, err := json.Marshal(stat)
 
@@ -279,6 +380,8 @@ func GetPlayerState() PState {
 	return g.playerState
 }
 
+// ChangePlayerState moves the player along in the game. UpPlayer starts the next ball, EndPlayer ends the
+// ball in play. Returns false if the state was not changed. See ChangeState for the full state machine.
 func ChangePlayerState(newState PState) bool {
 	g := GetMachine()
 	if g.playerState == newState {
@@ -286,17 +389,24 @@ func ChangePlayerState(newState PState) bool {
 		return false
 	}
 
-	g.playerState = newState
-	switch g.playerState {
+	var err error
+	switch newState {
 	case UpPlayer:
-		PlayerUp()
+		err = ChangeState(StateBallStarting)
 	case EndPlayer:
-		PlayerEnd()
+		err = ChangeState(StateBallEnding)
 	case FinishedPlayer:
+		g.playerState = newState
 		PlayerFinish()
+	default:
+		g.playerState = newState
 	}
-	return true
 
+	if err != nil {
+		log.Warnf("ChangePlayerState(%d): %v", newState, err)
+		return false
+	}
+	return true
 }
 
 func GetGameState() GState {
@@ -304,6 +414,8 @@ func GetGameState() GState {
 	return g.gameState
 }
 
+// ChangeGameState starts (InProgress) or ends (GameEnded) a game. Returns false if the state was not changed.
+// See ChangeState for the full state machine.
 func ChangeGameState(newState GState) bool {
 	g := GetMachine()
 	if g.gameState == newState {
@@ -311,15 +423,20 @@ func ChangeGameState(newState GState) bool {
 		return false
 	}
 
-	g.gameState = newState
-
-	switch g.gameState {
-	case GameEnded:
-		GameOver()
+	var err error
+	switch newState {
 	case InProgress:
-		GameStart()
+		err = ChangeState(StateGameStarted)
+	case GameEnded:
+		err = ChangeState(StateGameOver)
+	default:
+		return false
 	}
 
+	if err != nil {
+		log.Warnf("ChangeGameState(%d): %v", newState, err)
+		return false
+	}
 	return true
 }
 
@@ -333,8 +450,16 @@ func Quit() {
 	msg.id = QUIT
 	msg.value = 0
 
-	lampControl <- msg
+	//nothing is left on: the flippers are turned off on the SDU, and the held coils by goflip
+	FlipperControl(false)
+	AllCoilsOff()
+
+	solenoidSendMu.Lock()
 	solenoidControl <- msg
+	solenoidsClosed = true
+	solenoidSendMu.Unlock()
+
+	lampControl <- msg
 	BroadcastEvent(SwitchEvent{SwitchID: QUIT, Pressed: true})
 }
 
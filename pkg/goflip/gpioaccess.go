@@ -33,6 +33,9 @@ const (
 	noSound        = 15
 	ballInPlayDisp = 5
 	creditDisp     = 6
+	matchDisp      = 7
+
+	maxScoreDisplays = 4 //players after this have no score display on the backbox
 )
 
 //const blank byte = 0x0f
@@ -41,7 +44,7 @@ const (
 
 type displayMessage struct {
 	display int
-	value   int32
+	value   int64
 }
 
 type soundMessage struct {
@@ -49,9 +52,14 @@ type soundMessage struct {
 }
 
 type pwmMessage struct {
-	angle int
+	angle   int
+	channel int  //PCA9685 channel, for duty cycle messages
+	duty    int  //0-100 percent, for duty cycle messages
+	isDuty  bool //false to move the servo to angle
 }
 
+const pwmMaxCount = 4095 //PCA9685 is 12 bit
+
 var endLoop bool
 
 func gpioInit() {
@@ -84,6 +92,8 @@ subscriberloop:
 					_dsp.SetBallInPlay(int8(dspMsg.value))
 				case creditDisp:
 					_dsp.SetCredits(int8(dspMsg.value))
+				case matchDisp:
+					_dsp.SetMatch(int8(dspMsg.value))
 				}
 			}
 		case sndMsg := <-soundControl:
@@ -98,6 +108,12 @@ subscriberloop:
 
 		case pwmMessage := <-pWMControl:
 			//	log.Debugf("PWM angle is %v", pwmMessage.angle)
+			if pwmMessage.isDuty {
+				go func() {
+					_pca0.SetChannel(pwmMessage.channel, 0, pwmMessage.duty*pwmMaxCount/100)
+				}()
+				break
+			}
 			go func() {
 				_servo0.Angle(pwmMessage.angle)
 			}()
@@ -313,7 +329,7 @@ func runGPIO() {
 	}
 }*/
 
-func SetDisplay(display int, value int32) {
+func SetDisplay(display int, value int64) {
 	var msg displayMessage
 	msg.display = display
 	msg.value = value
@@ -331,11 +347,50 @@ func ShowDisplay(display int, on bool) {
 }
 
 func SetCreditDisp(value int8) {
-	SetDisplay(creditDisp, int32(value))
+	SetDisplay(creditDisp, int64(value))
 }
 
 func SetBallInPlayDisp(value int8) {
-	SetDisplay(ballInPlayDisp, int32(value))
+	SetDisplay(ballInPlayDisp, int64(value))
+}
+
+// SetMatchDisp shows the match value (00-90) in the ball in play digits, or blankScore to clear
+func SetMatchDisp(value int8) {
+	SetDisplay(matchDisp, int64(value))
+}
+
+// setPlayerDisplay shows the score on the player's display, if the player has one and no mode is using it
+func setPlayerDisplay(playerNumber int, score int64) {
+	if playerNumber < 1 || playerNumber > maxScoreDisplays {
+		return
+	}
+	if _, ok := modeDisplay(playerNumber); ok {
+		return
+	}
+	SetDisplay(playerNumber, score)
+}
+
+// refreshDisplay shows what should be on the display, from the highest priority active mode or the base game
+func refreshDisplay(display int) {
+	g := GetMachine()
+
+	if value, ok := modeDisplay(display); ok {
+		SetDisplay(display, value)
+		return
+	}
+
+	switch {
+	case display >= 1 && display <= maxScoreDisplays:
+		if display <= g.NumOfPlayers {
+			SetDisplay(display, PlayerScore(display))
+		} else {
+			SetDisplay(display, blankScore)
+		}
+	case display == ballInPlayDisp:
+		SetBallInPlayDisp(int8(g.BallInPlay))
+	case display == creditDisp:
+		showCredits()
+	}
 }
 
 func PlaySound(soundID byte) {
@@ -358,3 +413,19 @@ func ServoAngle(angle int) {
 	msg.angle = angle
 	pWMControl <- msg
 }
+
+// PWMDuty sets the duty cycle (0-100 percent) of a PCA9685 channel. Channel 0 is used by the servo
+func PWMDuty(channel int, duty int) {
+	if duty < 0 {
+		duty = 0
+	}
+	if duty > 100 {
+		duty = 100
+	}
+
+	var msg pwmMessage
+	msg.channel = channel
+	msg.duty = duty
+	msg.isDuty = true
+	pWMControl <- msg
+}
@@ -0,0 +1,332 @@
+package goflip
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+highScores keeps the grand champion and the top HighScoreConfig.Entries scores,
+saved in DataPath. At GameOver every player that made the table enters their
+initials, highest score first: the left and right flipper buttons move through the
+letters, and start selects the letter. Until LeftSwitch, RightSwitch and SelectSwitch
+are set, scores are kept with blank initials. The letter (1 = A .. 26 = Z, 27 = space) is shown on the player's
+display with the position in the ball in play display, and sent to the web interface.
+A new game can't be started (ChangeState to StateGameStarted) while
+IsEnteringInitials is true.
+
+With SegregateByBalls, a separate table is kept for each TotalBalls setting so that
+3 and 5 ball games are not mixed.
+*/
+
+const (
+	highScoreFile    = "highscores.json"
+	defaultTableName = "default"
+	initialsLetters  = "ABCDEFGHIJKLMNOPQRSTUVWXYZ "
+)
+
+// HighScoreConfig holds the operator settings for the high score table
+type HighScoreConfig struct {
+	Enabled          bool
+	Entries          int  //number of scores kept after the grand champion
+	InitialsLength   int  //number of letters entered
+	SegregateByBalls bool //keep a table for each TotalBalls setting
+	LeftSwitch       int  //moves to the previous letter
+	RightSwitch      int  //moves to the next letter
+	SelectSwitch     int  //selects the letter
+	EntryTimeout     time.Duration
+}
+
+// HighScore is a single entry in the high score table
+type HighScore struct {
+	Initials string
+	Score    int64
+	Date     time.Time
+}
+
+// HighScoreTable is the grand champion, followed by the top scores highest first
+type HighScoreTable struct {
+	GrandChampion HighScore
+	Entries       []HighScore
+}
+
+type highScoreState struct {
+	mu       sync.Mutex
+	tables   map[string]*HighScoreTable
+	entering bool
+	input    chan int //-1 previous letter, 1 next letter, 0 select
+}
+
+var highScores = highScoreState{
+	tables: make(map[string]*HighScoreTable),
+	input:  make(chan int, 10),
+}
+
+// HighScores returns a copy of the high score table for the current game settings
+func HighScores() HighScoreTable {
+	highScores.mu.Lock()
+	defer highScores.mu.Unlock()
+
+	t := highScoreTable()
+	return HighScoreTable{GrandChampion: t.GrandChampion, Entries: append([]HighScore(nil), t.Entries...)}
+}
+
+// AllHighScores returns a copy of every high score table, by table name
+func AllHighScores() map[string]HighScoreTable {
+	highScores.mu.Lock()
+	defer highScores.mu.Unlock()
+
+	tables := make(map[string]HighScoreTable, len(highScores.tables))
+	for name, t := range highScores.tables {
+		tables[name] = HighScoreTable{GrandChampion: t.GrandChampion, Entries: append([]HighScore(nil), t.Entries...)}
+	}
+	return tables
+}
+
+// ResetHighScores clears all of the high score tables (operator reset)
+func ResetHighScores() error {
+	highScores.mu.Lock()
+	defer highScores.mu.Unlock()
+
+	log.Infoln("highScores: resetting tables")
+	highScores.tables = make(map[string]*HighScoreTable)
+	return saveData(highScoreFile, highScores.tables)
+}
+
+// IsEnteringInitials returns true while a player is entering their initials
+func IsEnteringInitials() bool {
+	highScores.mu.Lock()
+	defer highScores.mu.Unlock()
+	return highScores.entering
+}
+
+// highScoreTable returns the table for the current game settings. Called with the lock held
+func highScoreTable() *HighScoreTable {
+	g := GetMachine()
+
+	name := defaultTableName
+	if g.HighScoreConfig.SegregateByBalls {
+		name = fmt.Sprintf("%d-ball", g.TotalBalls)
+	}
+
+	t, ok := highScores.tables[name]
+	if !ok {
+		t = &HighScoreTable{}
+		highScores.tables[name] = t
+	}
+	return t
+}
+
+// qualifies returns true if the score would make the table. Called with the lock held
+func (t *HighScoreTable) qualifies(score int64, entries int) bool {
+	if score <= 0 {
+		return false
+	}
+	if score > t.GrandChampion.Score || len(t.Entries) < entries {
+		return true
+	}
+	return len(t.Entries) > 0 && score > t.Entries[len(t.Entries)-1].Score
+}
+
+// add puts the score in the table, bumping the grand champion down if it was beaten. Called with the lock held
+func (t *HighScoreTable) add(hs HighScore, entries int) {
+	if hs.Score > t.GrandChampion.Score {
+		if t.GrandChampion.Score > 0 {
+			t.Entries = append(t.Entries, t.GrandChampion)
+		}
+		t.GrandChampion = hs
+	} else {
+		t.Entries = append(t.Entries, hs)
+	}
+
+	sort.SliceStable(t.Entries, func(i, j int) bool { return t.Entries[i].Score > t.Entries[j].Score })
+	if len(t.Entries) > entries {
+		t.Entries = t.Entries[:entries]
+	}
+}
+
+// canEnterInitials returns true if all of the switches needed to enter initials are set
+func (cfg HighScoreConfig) canEnterInitials() bool {
+	return cfg.LeftSwitch != NoSwitch && cfg.RightSwitch != NoSwitch && cfg.SelectSwitch != NoSwitch
+}
+
+// checkHighScores is called at GameOver for a completed game. Players that made the table enter their
+// initials, highest score first. The scores are copied here, as the next game clears them
+func checkHighScores() {
+	g := GetMachine()
+	cfg := g.HighScoreConfig
+	if !cfg.Enabled {
+		return
+	}
+
+	type playerScore struct {
+		player int
+		score  int64
+	}
+
+	highScores.mu.Lock()
+	t := highScoreTable()
+	var players []playerScore
+	for p := 1; p <= g.NumOfPlayers; p++ {
+		if score := PlayerScore(p); t.qualifies(score, cfg.Entries) {
+			players = append(players, playerScore{player: p, score: score})
+		}
+	}
+
+	sort.SliceStable(players, func(i, j int) bool { return players[i].score > players[j].score })
+
+	if len(players) > 0 && !cfg.canEnterInitials() {
+		//no switches to enter them with, the scores are kept with blank initials
+		blank := strings.Repeat(" ", cfg.InitialsLength)
+		for _, ps := range players {
+			t.add(HighScore{Initials: blank, Score: ps.score, Date: time.Now()}, cfg.Entries)
+		}
+		err := saveData(highScoreFile, highScores.tables)
+		highScores.mu.Unlock()
+
+		if err != nil {
+			log.Errorf("highScores: unable to save: %v", err)
+		}
+		return
+	}
+
+	if len(players) == 0 {
+		highScores.mu.Unlock()
+		return
+	}
+	highScores.entering = true
+	highScores.mu.Unlock()
+
+	go func() {
+		for _, ps := range players {
+			//a higher score entered before this one may have pushed it off the table
+			highScores.mu.Lock()
+			made := highScoreTable().qualifies(ps.score, cfg.Entries)
+			highScores.mu.Unlock()
+			if !made {
+				continue
+			}
+
+			initials := enterInitials(ps.player)
+
+			highScores.mu.Lock()
+			highScoreTable().add(HighScore{Initials: initials, Score: ps.score, Date: time.Now()}, cfg.Entries)
+			err := saveData(highScoreFile, highScores.tables)
+			highScores.mu.Unlock()
+
+			if err != nil {
+				log.Errorf("highScores: unable to save: %v", err)
+			}
+			log.Infof("highScores: player %d entered %s", ps.player, initials)
+		}
+
+		highScores.mu.Lock()
+		highScores.entering = false
+		highScores.mu.Unlock()
+
+		showLastScores(true)
+		SetBallInPlayDisp(blankScore)
+		StartAttract()
+	}()
+}
+
+// enterInitials lets the player pick their initials with the flipper and start buttons
+func enterInitials(player int) string {
+	g := GetMachine()
+	cfg := g.HighScoreConfig
+
+	//drop any input left over from the game
+	for len(highScores.input) > 0 {
+		<-highScores.input
+	}
+
+	var initials []byte
+	letter := 0
+
+	for len(initials) < cfg.InitialsLength {
+		showInitialsEntry(player, string(initials), len(initials), letter)
+
+		select {
+		case in := <-highScores.input:
+			switch in {
+			case -1:
+				letter = (letter + len(initialsLetters) - 1) % len(initialsLetters)
+			case 1:
+				letter = (letter + 1) % len(initialsLetters)
+			default:
+				initials = append(initials, initialsLetters[letter])
+			}
+		case <-time.After(cfg.EntryTimeout):
+			log.Debugf("highScores: initials entry timed out for player %d", player)
+			for len(initials) < cfg.InitialsLength {
+				initials = append(initials, ' ')
+			}
+		}
+	}
+
+	return string(initials)
+}
+
+func showInitialsEntry(player int, initials string, position int, letter int) {
+	setPlayerDisplay(player, int64(letter+1))
+	SetBallInPlayDisp(int8(position + 1))
+
+	entry := struct {
+		Player   int
+		Initials string
+		Letter   string
+	}{player, initials, string(initialsLetters[letter])}
+
+	b, err := json.Marshal(entry)
+	if err != nil {
+		log.Errorln("Error in marshalling:", err)
+		return
+	}
+	Broadcast("initials", string(b))
+}
+
+// initialsSwitch is called for every switch event to drive the initials entry
+func initialsSwitch(sw SwitchEvent) {
+	g := GetMachine()
+	cfg := g.HighScoreConfig
+
+	if !sw.Pressed || !IsEnteringInitials() {
+		return
+	}
+
+	in := 0
+	switch sw.SwitchID {
+	case cfg.LeftSwitch:
+		in = -1
+	case cfg.RightSwitch:
+		in = 1
+	case cfg.SelectSwitch:
+		in = 0
+	default:
+		return
+	}
+
+	select {
+	case highScores.input <- in:
+	default:
+	}
+}
+
+// loadHighScores is called at Init
+func loadHighScores() {
+	highScores.mu.Lock()
+	defer highScores.mu.Unlock()
+
+	if err := loadData(highScoreFile, &highScores.tables); err != nil {
+		log.Errorf("highScores: unable to load: %v", err)
+	}
+	if highScores.tables == nil {
+		highScores.tables = make(map[string]*HighScoreTable)
+	}
+}
@@ -0,0 +1,65 @@
+package goflip
+
+import "testing"
+
+func TestHighScoreTableQualifies(t *testing.T) {
+	full := HighScoreTable{
+		GrandChampion: HighScore{Initials: "GC ", Score: 5000},
+		Entries:       []HighScore{{Score: 4000}, {Score: 3000}},
+	}
+
+	tests := []struct {
+		name    string
+		table   HighScoreTable
+		score   int64
+		entries int
+		want    bool
+	}{
+		{"empty table", HighScoreTable{}, 100, 2, true},
+		{"zero score", HighScoreTable{}, 0, 2, false},
+		{"room left", HighScoreTable{GrandChampion: HighScore{Score: 5000}}, 10, 2, true},
+		{"beats the grand champion", full, 6000, 2, true},
+		{"beats the lowest entry", full, 3500, 2, true},
+		{"ties the lowest entry", full, 3000, 2, false},
+		{"below the lowest entry", full, 2000, 2, false},
+		{"no entries kept", HighScoreTable{GrandChampion: HighScore{Score: 5000}}, 4000, 0, false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.table.qualifies(tt.score, tt.entries); got != tt.want {
+			t.Errorf("%s: qualifies(%d) = %v, want %v", tt.name, tt.score, got, tt.want)
+		}
+	}
+}
+
+func TestHighScoreTableAdd(t *testing.T) {
+	var table HighScoreTable
+
+	table.add(HighScore{Initials: "AAA", Score: 1000}, 3)
+	if table.GrandChampion.Initials != "AAA" || len(table.Entries) != 0 {
+		t.Fatalf("first score: got %+v, want AAA as grand champion and no entries", table)
+	}
+
+	//beating the grand champion bumps it down to the entries
+	table.add(HighScore{Initials: "BBB", Score: 2000}, 3)
+	if table.GrandChampion.Initials != "BBB" {
+		t.Errorf("grand champion = %s, want BBB", table.GrandChampion.Initials)
+	}
+	if len(table.Entries) != 1 || table.Entries[0].Initials != "AAA" {
+		t.Errorf("entries = %+v, want AAA", table.Entries)
+	}
+
+	table.add(HighScore{Initials: "CCC", Score: 500}, 3)
+	table.add(HighScore{Initials: "DDD", Score: 1500}, 3)
+	table.add(HighScore{Initials: "EEE", Score: 700}, 3)
+
+	want := []string{"DDD", "AAA", "EEE"}
+	if len(table.Entries) != len(want) {
+		t.Fatalf("entries = %+v, want %v", table.Entries, want)
+	}
+	for i, initials := range want {
+		if table.Entries[i].Initials != initials {
+			t.Errorf("entry %d = %s, want %s", i, table.Entries[i].Initials, initials)
+		}
+	}
+}
@@ -46,7 +46,7 @@ func (dsp *I2CDisplay) ClearDisplay() error {
 	return dsp.SetDisplay(6, 0) //hacky
 }
 
-func (dsp *I2CDisplay) SetDisplay(dspNumber int8, value int32) error {
+func (dsp *I2CDisplay) SetDisplay(dspNumber int8, value int64) error {
 
 	val := setScore(value)
 
@@ -64,10 +64,10 @@ func blankDisplay() [7]byte {
 	return [7]byte{blank, blank, blank, blank, blank, blank, blank} //initialize to blank disp
 }
 
-func numToArray(number int32) ([]byte, error) {
+func numToArray(number int64) ([]byte, error) {
 	var scoreArr []byte
 
-	var remainder int32
+	var remainder int64
 	tmpScore := number
 
 	for {
@@ -84,13 +84,19 @@ func numToArray(number int32) ([]byte, error) {
 }
 
 // setScore assumption is 7 digit display, so we will blank all remaining digits the score passed in didn't set
-func setScore(score int32) [7]byte {
-	scoreArr, _ := numToArray(score)
-
+func setScore(score int64) [7]byte {
 	dsp := blankDisplay()
+	if score < 0 {
+		return dsp
+	}
+
+	scoreArr, _ := numToArray(score)
 
-	//copy the score into the display array
+	//copy the score into the display array, only the lowest 7 digits fit
 	for i, num := range scoreArr {
+		if i >= len(dsp) {
+			break
+		}
 		dsp[i] = num
 	}
 	return dsp
@@ -116,7 +122,7 @@ func (dsp *I2CDisplay) SetBallInPlay(ball int8) {
 		return
 	}
 
-	ballArr, _ := numToArray(int32(ball))
+	ballArr, _ := numToArray(int64(ball))
 
 	if len(ballArr) == 2 {
 		dsp.creditDisplay[4] = ballArr[0]
@@ -138,7 +144,7 @@ func (dsp *I2CDisplay) SetCredits(credit int8) {
 		return
 	}
 
-	creditArr, _ := numToArray(int32(credit))
+	creditArr, _ := numToArray(int64(credit))
 
 	if len(creditArr) == 2 {
 		dsp.creditDisplay[0] = creditArr[0]
@@ -150,3 +156,18 @@ func (dsp *I2CDisplay) SetCredits(credit int8) {
 
 	dsp.WriteToDisplay(0, dsp.creditDisplay)
 }
+
+// SetMatch shows the match value on the same digits as ball in play. Unlike ball in play, both digits are always lit (00, 10, 20..)
+func (dsp *I2CDisplay) SetMatch(match int8) {
+	if match == blankScore {
+		dsp.creditDisplay[4] = blank
+		dsp.creditDisplay[5] = blank
+		dsp.WriteToDisplay(0, dsp.creditDisplay)
+		return
+	}
+
+	dsp.creditDisplay[4] = byte(match % 10)
+	dsp.creditDisplay[5] = byte((match / 10) % 10)
+
+	dsp.WriteToDisplay(0, dsp.creditDisplay)
+}
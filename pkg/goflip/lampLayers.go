@@ -0,0 +1,153 @@
+package goflip
+
+import "fmt"
+
+/*
+lampLayers decides which state each lamp shows. Every lamp can be set in any of the
+layers below, and the LDU is sent the state from the highest layer that has set it:
+
+	LayerService - set with SetServiceLamp while in service mode (lamp tests)
+	LayerShow    - lamp shows that are playing (see PlayLampShow)
+	LayerMode    - active Modes, highest priority mode first (see Mode.SetLamp)
+	LayerBase    - the base game, set with SetLampState, LampOn, LampOff..
+
+When a layer lets go of a lamp (the mode stops, the show ends, ClearLampLayer..) the
+lamp goes back to what the layer beneath it has. The service layer is cleared when
+the machine leaves service mode.
+*/
+
+// LampLayer is a level in the lamp priority stack
+type LampLayer int
+
+const (
+	LayerBase LampLayer = iota
+	LayerMode
+	LayerShow
+	LayerService
+)
+
+var lampLayerNames = []string{"base", "mode", "show", "service"}
+
+func (l LampLayer) String() string {
+	if l < LayerBase || int(l) >= len(lampLayerNames) {
+		return fmt.Sprintf("LampLayer(%d)", int(l))
+	}
+	return lampLayerNames[l]
+}
+
+// LampStatus is the state showing for a lamp, along with the state of each layer that has set it
+type LampStatus struct {
+	Lamp   int
+	State  int               //the state showing
+	Layer  LampLayer         //the layer the state is from
+	Layers map[LampLayer]int //state set by each layer, missing if the layer hasn't set the lamp
+}
+
+// GetLampState returns the state showing for the lamp, from the highest layer that has set it
+func GetLampState(lampID int) int {
+	return GetLampStatus(lampID).State
+}
+
+// GetLampStatus returns the state showing for the lamp, and the state each layer has set it to
+func GetLampStatus(lampID int) LampStatus {
+	status := LampStatus{Lamp: lampID, State: Off, Layer: LayerBase, Layers: make(map[LampLayer]int)}
+
+	for l := LayerBase; l <= LayerService; l++ {
+		if state, ok := LayerLampState(l, lampID); ok {
+			status.Layers[l] = state
+			status.State = state
+			status.Layer = l
+		}
+	}
+	return status
+}
+
+// LayerLampState returns the state of the lamp in a single layer, and false if the layer hasn't set it
+func LayerLampState(layer LampLayer, lampID int) (int, bool) {
+	switch layer {
+	case LayerBase:
+		return layerMapState(GetMachine().lampStates, lampID)
+	case LayerMode:
+		return modeLampState(lampID)
+	case LayerShow:
+		return lampShowLampState(lampID)
+	case LayerService:
+		return layerMapState(GetMachine().serviceLamps, lampID)
+	}
+	return Off, false
+}
+
+// SetServiceLamp sets the state of the lamp over every other layer. Used by service mode for lamp tests
+func SetServiceLamp(lampID int, state int) {
+	g := GetMachine()
+	g.lampMu.Lock()
+	g.serviceLamps[lampID] = state
+	g.lampMu.Unlock()
+
+	refreshLamp(lampID)
+}
+
+// ClearServiceLamp gives the lamp back to the layers beneath service mode
+func ClearServiceLamp(lampID int) {
+	g := GetMachine()
+	g.lampMu.Lock()
+	delete(g.serviceLamps, lampID)
+	g.lampMu.Unlock()
+
+	refreshLamp(lampID)
+}
+
+// ClearLampLayer removes every lamp set in the layer, so the lamps show what is beneath it.
+// Clearing LayerShow stops all of the lamp shows. Clearing LayerMode clears the lamps of the
+// active modes, but leaves the modes running
+func ClearLampLayer(layer LampLayer) {
+	g := GetMachine()
+
+	switch layer {
+	case LayerBase:
+		g.lampMu.Lock()
+		lamps := clearLayerMap(g.lampStates)
+		g.lampMu.Unlock()
+		refreshLamps(lamps)
+	case LayerMode:
+		for _, m := range ActiveModes() {
+			m.mu.Lock()
+			lamps := clearLayerMap(m.lamps)
+			m.mu.Unlock()
+			refreshLamps(lamps)
+		}
+	case LayerShow:
+		StopAllLampShows()
+	case LayerService:
+		g.lampMu.Lock()
+		lamps := clearLayerMap(g.serviceLamps)
+		g.lampMu.Unlock()
+		refreshLamps(lamps)
+	}
+}
+
+// layerMapState looks the lamp up in one of the layers kept as a map
+func layerMapState(states map[int]int, lampID int) (int, bool) {
+	g := GetMachine()
+	g.lampMu.Lock()
+	defer g.lampMu.Unlock()
+
+	state, ok := states[lampID]
+	return state, ok
+}
+
+// clearLayerMap empties the layer, returning the lamps that were in it. Called with the layer's lock held
+func clearLayerMap(states map[int]int) []int {
+	lamps := make([]int, 0, len(states))
+	for l := range states {
+		lamps = append(lamps, l)
+		delete(states, l)
+	}
+	return lamps
+}
+
+func refreshLamps(lamps []int) {
+	for _, l := range lamps {
+		refreshLamp(l)
+	}
+}
@@ -0,0 +1,313 @@
+package goflip
+
+import (
+	"sync"
+	"time"
+)
+
+/*
+lampPatterns are blink patterns run by goflip instead of the LDU firmware. A pattern is
+a string of bits, one for every Step, repeated Repeat times (0 for forever) before the
+lamp settles on Final. For example, blink three times then stay on:
+
+	blink3 := RegisterLampPattern(&LampPattern{Name: "blink3", Bits: "10", Step: 150 * time.Millisecond, Repeat: 3, Final: On})
+	LampOn(lamp) // later..
+	SetLampState(lamp, blink3)
+
+RegisterLampPattern returns a lamp state, which can be used anywhere the classic lamp
+states are (SetLampState, Mode.SetLamp, lamp shows by name..). Patterns that repeat
+forever run off a shared clock, so every lamp running the pattern is in step. A lamp
+that has finished a pattern stays on Final until it is set to another state.
+SetLampPhase offsets a lamp by a number of steps, so a group of lamps running the same
+pattern can chase or alternate (see LampGroup.SetPattern).
+
+FadeIn and FadeOut ramp the lamp on and off, on outputs that can be dimmed (RGB lamps).
+Other lamps switch at the half way point of the fade.
+*/
+
+const (
+	lampPatternBase    = 0x10 //lamp states from here up are patterns
+	lampPatternClock   = 20 * time.Millisecond
+	lampPatternTimer   = "lampPatterns"
+	defaultPatternStep = 100 * time.Millisecond
+	lampPatternOnLevel = 0.5 //level at which lamps that can't be dimmed turn on
+)
+
+// LampPattern is a host timed blink pattern
+type LampPattern struct {
+	Name    string
+	Bits    string        //'1' for on, anything else for off. One character every Step
+	Step    time.Duration //0 for the default (100ms)
+	Repeat  int           //number of times through the bits. 0 for forever
+	Final   int           //On or Off, the state once the repeats are done
+	FadeIn  time.Duration //time to ramp on, for lamps that can be dimmed
+	FadeOut time.Duration //time to ramp off, for lamps that can be dimmed
+}
+
+type activePattern struct {
+	state int
+	start time.Time
+	sent  int
+}
+
+type lampPatternState struct {
+	mu       sync.Mutex
+	patterns []*LampPattern
+	active   map[int]*activePattern //lamps running a pattern
+	finished map[int]int            //lamps left on the Final of a pattern that is done, by pattern state
+	phases   map[int]int
+}
+
+var lampPatterns = lampPatternState{
+	active:   make(map[int]*activePattern),
+	finished: make(map[int]int),
+	phases:   make(map[int]int),
+}
+
+var lampPatternEpoch = time.Now()
+
+// RegisterLampPattern adds the pattern, and returns the lamp state that shows it. A pattern with the same name is replaced
+func RegisterLampPattern(p *LampPattern) int {
+	lampPatterns.mu.Lock()
+	defer lampPatterns.mu.Unlock()
+
+	for i, existing := range lampPatterns.patterns {
+		if existing.Name == p.Name {
+			lampPatterns.patterns[i] = p
+			return lampPatternBase + i
+		}
+	}
+
+	lampPatterns.patterns = append(lampPatterns.patterns, p)
+	return lampPatternBase + len(lampPatterns.patterns) - 1
+}
+
+// LampPatternState returns the lamp state for the named pattern
+func LampPatternState(name string) (int, bool) {
+	lampPatterns.mu.Lock()
+	defer lampPatterns.mu.Unlock()
+
+	for i, p := range lampPatterns.patterns {
+		if p.Name == name {
+			return lampPatternBase + i, true
+		}
+	}
+	return Off, false
+}
+
+// SetLampPhase offsets the lamp's patterns by a number of steps
+func SetLampPhase(lampID int, steps int) {
+	lampPatterns.mu.Lock()
+	if steps == 0 {
+		delete(lampPatterns.phases, lampID)
+	} else {
+		lampPatterns.phases[lampID] = steps
+	}
+	lampPatterns.mu.Unlock()
+
+	refreshLamp(lampID)
+}
+
+// SetPattern shows the pattern state on the group, each lamp phaseStep steps behind the one before it
+func (grp *LampGroup) SetPattern(state int, phaseStep int) {
+	for i, l := range grp.Lamps {
+		lampPatterns.mu.Lock()
+		lampPatterns.phases[l] = -i * phaseStep
+		lampPatterns.mu.Unlock()
+	}
+	grp.SetAll(state)
+}
+
+func isLampPattern(state int) bool {
+	return state >= lampPatternBase
+}
+
+func (p *LampPattern) step() time.Duration {
+	if p.Step <= 0 {
+		return defaultPatternStep
+	}
+	return p.Step
+}
+
+// done returns true if the pattern has finished its repeats, elapsed into it. Patterns that repeat forever are never done
+func (p *LampPattern) done(elapsed time.Duration) bool {
+	return p.Repeat > 0 && elapsed >= p.step()*time.Duration(len(p.Bits)*p.Repeat)
+}
+
+// finalLevel is the level the pattern settles on once it is done
+func (p *LampPattern) finalLevel() float64 {
+	if p.Final == Off {
+		return 0
+	}
+	return 1
+}
+
+// level returns how far on (0 to 1) the pattern is, elapsed into it
+func (p *LampPattern) level(elapsed time.Duration) float64 {
+	step := p.step()
+	if len(p.Bits) == 0 || elapsed < 0 {
+		return 0
+	}
+	if p.done(elapsed) {
+		return p.finalLevel()
+	}
+
+	n := int64(len(p.Bits))
+	idx := int64(elapsed / step)
+
+	on := p.Bits[idx%n] == '1'
+	wasOn := p.Bits[(idx+n-1)%n] == '1'
+	into := elapsed % step
+
+	switch {
+	case on && !wasOn && p.FadeIn > 0 && into < p.FadeIn:
+		return float64(into) / float64(p.FadeIn)
+	case !on && wasOn && p.FadeOut > 0 && into < p.FadeOut:
+		return 1 - float64(into)/float64(p.FadeOut)
+	case on:
+		return 1
+	}
+	return 0
+}
+
+// lampPatternLevel returns how far on (0 to 1) the lamp is for the state. The classic states are fully on or off
+func lampPatternLevel(lampID int, state int, now time.Time) float64 {
+	if !isLampPattern(state) {
+		if state == Off {
+			return 0
+		}
+		return 1
+	}
+
+	lampPatterns.mu.Lock()
+	defer lampPatterns.mu.Unlock()
+
+	p, elapsed := lampPatternElapsed(lampID, state, now)
+	if p == nil {
+		if f, ok := lampPatterns.finished[lampID]; ok && f == state {
+			return lampPatterns.patterns[state-lampPatternBase].finalLevel()
+		}
+		return 0
+	}
+	return p.level(elapsed)
+}
+
+// lampPatternElapsed returns the pattern for the state and how far the lamp is into it. The pattern is nil
+// for an unknown state, or a finished one. Called with the lock held
+func lampPatternElapsed(lampID int, state int, now time.Time) (*LampPattern, time.Duration) {
+	i := state - lampPatternBase
+	if i >= len(lampPatterns.patterns) {
+		return nil, 0
+	}
+	p := lampPatterns.patterns[i]
+
+	start := lampPatternEpoch
+	if p.Repeat > 0 {
+		if f, ok := lampPatterns.finished[lampID]; ok && f == state {
+			return nil, 0
+		}
+		if a, ok := lampPatterns.active[lampID]; ok && a.state == state {
+			start = a.start
+		} else {
+			start = now
+		}
+	}
+
+	return p, now.Sub(start) + time.Duration(lampPatterns.phases[lampID])*p.step()
+}
+
+// resolveLampPattern returns the classic state to send to the LDU for the lamp
+func resolveLampPattern(lampID int, state int, now time.Time) int {
+	if !isLampPattern(state) {
+		return state
+	}
+	if lampPatternLevel(lampID, state, now) >= lampPatternOnLevel {
+		return On
+	}
+	return Off
+}
+
+// trackLampPattern is called whenever the state showing for a lamp may have changed, to start and stop running its pattern.
+// The timer is started and cancelled with the lock held, so it is always running while there are active patterns
+func trackLampPattern(lampID int, state int) {
+	lampPatterns.mu.Lock()
+	defer lampPatterns.mu.Unlock()
+
+	if f, ok := lampPatterns.finished[lampID]; ok && f == state {
+		return
+	}
+	delete(lampPatterns.finished, lampID)
+
+	a, ok := lampPatterns.active[lampID]
+	if !isLampPattern(state) {
+		if ok {
+			stopLampPattern(lampID)
+		}
+		return
+	}
+
+	if ok && a.state == state {
+		return
+	}
+
+	lampPatterns.active[lampID] = &activePattern{state: state, start: time.Now(), sent: -1}
+	if len(lampPatterns.active) == 1 {
+		Every(lampPatternTimer, ScopeMachine, lampPatternClock, lampPatternTick)
+	}
+}
+
+// stopLampPattern stops running the lamp's pattern, and the timer if it was the last one. Called with the lock held
+func stopLampPattern(lampID int) {
+	delete(lampPatterns.active, lampID)
+	if len(lampPatterns.active) == 0 {
+		CancelTimer(lampPatternTimer)
+	}
+}
+
+// finishLampPattern leaves the lamp on the Final of the pattern state, if it is done
+func finishLampPattern(lampID int, state int, now time.Time) {
+	lampPatterns.mu.Lock()
+	defer lampPatterns.mu.Unlock()
+
+	a, ok := lampPatterns.active[lampID]
+	if !ok || a.state != state {
+		return
+	}
+	if p, elapsed := lampPatternElapsed(lampID, state, now); p == nil || !p.done(elapsed) {
+		return
+	}
+
+	stopLampPattern(lampID)
+	lampPatterns.finished[lampID] = state
+}
+
+// lampPatternTick sends the lamps running patterns to the LDU when they change between on and off
+func lampPatternTick() {
+	lampPatterns.mu.Lock()
+	lamps := make(map[int]int, len(lampPatterns.active))
+	for l, a := range lampPatterns.active {
+		lamps[l] = a.state
+	}
+	lampPatterns.mu.Unlock()
+
+	now := time.Now()
+	for l, state := range lamps {
+		if !IsRGBLamp(l) {
+			value := resolveLampPattern(l, state, now)
+
+			lampPatterns.mu.Lock()
+			a, ok := lampPatterns.active[l]
+			changed := ok && a.state == state && a.sent != value
+			if changed {
+				a.sent = value
+			}
+			lampPatterns.mu.Unlock()
+
+			if changed {
+				lampControl <- deviceMessage{id: l, value: value}
+			}
+		}
+
+		finishLampPattern(l, state, now)
+	}
+}
@@ -0,0 +1,358 @@
+package goflip
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+/*
+lampShows plays timed sequences of lamp states. A show is a list of steps, each
+setting some lamps and holding for a number of ticks of the shared lamp show clock
+(GoFlip.LampShowClock), so every show that is playing stays in step with the others.
+Shows are built in Go or loaded from a yaml or json file, for example:
+
+	name: chase
+	loop: true
+	groups:
+	  lanes: [1, 2, 3]
+	steps:
+	  - lamps: {lanes: off, "1": on}
+	    hold: 2
+	  - lamps: {"1": off, "2": on}
+	    hold: 2
+
+Lamps are given by number, by one of the show's groups, or by a registered lamp
+or lamp group name (see RegisterLamp). Groups are set first and then single lamps, so
+a lamp named in a step wins over a group it is in. States are on, off, slow and fast, or the name
+of a registered lamp pattern. A playing show shows over the base game and modes; when
+it stops, the lamps go back to what is underneath.
+*/
+
+const defaultLampShowClock = 50 * time.Millisecond
+
+const lampShowTimer = "lampShows"
+
+// LampShowStep is a single step of a lamp show
+type LampShowStep struct {
+	Lamps map[string]string `json:"lamps" yaml:"lamps"` //lamp number or group name, to state (on, off, slow, fast)
+	Hold  int               `json:"hold" yaml:"hold"`   //clock ticks to hold this step for, at least 1
+}
+
+// LampShow is a sequence of lamp steps
+type LampShow struct {
+	Name   string           `json:"name" yaml:"name"`
+	Loop   bool             `json:"loop" yaml:"loop"`
+	Groups map[string][]int `json:"groups" yaml:"groups"`
+	Steps  []LampShowStep   `json:"steps" yaml:"steps"`
+
+	steps []map[int]int //resolved steps, lamp ID to lamp state
+}
+
+// LampShowOptions change how a show is played
+type LampShowOptions struct {
+	Speed float64 //1 is normal speed, 2 twice as fast. 0 is treated as 1
+	Loops int     //number of times to play the show. 0 uses the show's Loop setting (forever or once)
+}
+
+type playingShow struct {
+	show      *LampShow
+	step      int
+	ticksLeft float64
+	speed     float64
+	loopsLeft int //-1 for forever
+	lamps     map[int]int
+}
+
+type lampShowState struct {
+	mu      sync.Mutex
+	library map[string]*LampShow
+	playing []*playingShow
+}
+
+var lampShows = lampShowState{library: make(map[string]*LampShow)}
+
+var lampShowStates = map[string]int{
+	"off":  Off,
+	"on":   On,
+	"slow": SlowBlink,
+	"fast": FastBlink,
+}
+
+// LoadLampShow reads a show from a yaml (.yaml, .yml) or json file and registers it
+func LoadLampShow(path string) (*LampShow, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	show := &LampShow{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, show)
+	case ".json":
+		err = json.Unmarshal(b, show)
+	default:
+		err = fmt.Errorf("unknown lamp show file type %s", path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if show.Name == "" {
+		show.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+
+	return show, RegisterLampShow(show)
+}
+
+// RegisterLampShow checks the show and adds it to the shows that can be played by name
+func RegisterLampShow(show *LampShow) error {
+	if len(show.Steps) == 0 {
+		return fmt.Errorf("lamp show %s has no steps", show.Name)
+	}
+
+	type lampEntry struct {
+		name  string
+		ids   []int
+		group bool
+		state int
+	}
+
+	steps := make([]map[int]int, len(show.Steps))
+	for i, step := range show.Steps {
+		var entries []lampEntry
+		for lamp, stateName := range step.Lamps {
+			state, ok := lampShowStates[strings.ToLower(stateName)]
+			if !ok {
+				state, ok = LampPatternState(stateName)
+			}
+			if !ok {
+				return fmt.Errorf("lamp show %s step %d: unknown state %s", show.Name, i, stateName)
+			}
+
+			ids, group, err := show.resolveLamp(lamp)
+			if err != nil {
+				return fmt.Errorf("lamp show %s step %d: %v", show.Name, i, err)
+			}
+			entries = append(entries, lampEntry{name: lamp, ids: ids, group: group, state: state})
+		}
+
+		//the map is in no order, so groups go first (by name), then the single lamps over them
+		sort.Slice(entries, func(a, b int) bool {
+			if entries[a].group != entries[b].group {
+				return entries[a].group
+			}
+			return entries[a].name < entries[b].name
+		})
+
+		steps[i] = make(map[int]int)
+		for _, e := range entries {
+			for _, id := range e.ids {
+				steps[i][id] = e.state
+			}
+		}
+	}
+
+	lampShows.mu.Lock()
+	defer lampShows.mu.Unlock()
+
+	show.steps = steps
+	lampShows.library[show.Name] = show
+	return nil
+}
+
+// resolveLamp turns a lamp number, lamp name or group name into lamp IDs, and whether it was a group
+func (show *LampShow) resolveLamp(lamp string) ([]int, bool, error) {
+	if ids, ok := show.Groups[lamp]; ok {
+		return ids, true, nil
+	}
+	if ids, ok := resolveLampName(lamp); ok {
+		return ids, GetLampGroup(lamp) != nil, nil
+	}
+
+	id, err := strconv.Atoi(lamp)
+	if err != nil {
+		return nil, false, fmt.Errorf("unknown lamp %s", lamp)
+	}
+	return []int{id}, false, nil
+}
+
+// PlayLampShow starts the named show. If it is already playing, it is started over
+func PlayLampShow(name string, opts LampShowOptions) error {
+	g := GetMachine()
+
+	lampShows.mu.Lock()
+	show, ok := lampShows.library[name]
+	if !ok {
+		lampShows.mu.Unlock()
+		return fmt.Errorf("unknown lamp show %s", name)
+	}
+	lampShows.mu.Unlock()
+
+	StopLampShow(name)
+
+	p := &playingShow{
+		show:      show,
+		speed:     opts.Speed,
+		loopsLeft: opts.Loops,
+		lamps:     make(map[int]int),
+	}
+	if p.speed <= 0 {
+		p.speed = 1
+	}
+	if p.loopsLeft == 0 {
+		p.loopsLeft = 1
+		if show.Loop {
+			p.loopsLeft = -1
+		}
+	}
+
+	lampShows.mu.Lock()
+	lampShows.playing = append(lampShows.playing, p)
+	first := len(lampShows.playing) == 1
+	lampShows.mu.Unlock()
+
+	log.Debugf("lampShows: playing %s", name)
+	p.enterStep(0)
+
+	if first {
+		clock := g.LampShowClock
+		if clock <= 0 {
+			clock = defaultLampShowClock
+		}
+		Every(lampShowTimer, ScopeMachine, clock, lampShowTick)
+	}
+	return nil
+}
+
+// StopLampShow stops the named show, putting its lamps back to what is underneath
+func StopLampShow(name string) {
+	lampShows.mu.Lock()
+	var stopped *playingShow
+	for i, p := range lampShows.playing {
+		if p.show.Name == name {
+			stopped = p
+			lampShows.playing = append(lampShows.playing[:i], lampShows.playing[i+1:]...)
+			break
+		}
+	}
+	empty := len(lampShows.playing) == 0
+	lampShows.mu.Unlock()
+
+	if stopped == nil {
+		return
+	}
+
+	if empty {
+		CancelTimer(lampShowTimer)
+	}
+
+	log.Debugf("lampShows: stopped %s", name)
+	for l := range stopped.lamps {
+		refreshLamp(l)
+	}
+}
+
+// StopAllLampShows stops every show that is playing
+func StopAllLampShows() {
+	lampShows.mu.Lock()
+	var names []string
+	for _, p := range lampShows.playing {
+		names = append(names, p.show.Name)
+	}
+	lampShows.mu.Unlock()
+
+	for _, n := range names {
+		StopLampShow(n)
+	}
+}
+
+// IsLampShowPlaying returns true if the named show is playing
+func IsLampShowPlaying(name string) bool {
+	lampShows.mu.Lock()
+	defer lampShows.mu.Unlock()
+
+	for _, p := range lampShows.playing {
+		if p.show.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// enterStep sets the lamps for the step
+func (p *playingShow) enterStep(step int) {
+	lampShows.mu.Lock()
+	p.step = step
+	hold := p.show.Steps[step].Hold
+	if hold < 1 {
+		hold = 1
+	}
+	p.ticksLeft = float64(hold) / p.speed
+
+	changed := make([]int, 0, len(p.show.steps[step]))
+	for l, state := range p.show.steps[step] {
+		p.lamps[l] = state
+		changed = append(changed, l)
+	}
+	lampShows.mu.Unlock()
+
+	for _, l := range changed {
+		refreshLamp(l)
+	}
+}
+
+// lampShowTick is called on every tick of the lamp show clock to move the shows along
+func lampShowTick() {
+	lampShows.mu.Lock()
+	playing := append([]*playingShow(nil), lampShows.playing...)
+	lampShows.mu.Unlock()
+
+	for _, p := range playing {
+		lampShows.mu.Lock()
+		p.ticksLeft--
+		next := -1
+		done := false
+		if p.ticksLeft <= 0 {
+			next = p.step + 1
+			if next >= len(p.show.Steps) {
+				next = 0
+				if p.loopsLeft > 0 {
+					p.loopsLeft--
+				}
+				done = p.loopsLeft == 0
+			}
+		}
+		lampShows.mu.Unlock()
+
+		switch {
+		case done:
+			StopLampShow(p.show.Name)
+		case next >= 0:
+			p.enterStep(next)
+		}
+	}
+}
+
+// lampShowLampState returns the lamp state from the most recently started show that has set it
+func lampShowLampState(lampID int) (int, bool) {
+	lampShows.mu.Lock()
+	defer lampShows.mu.Unlock()
+
+	for i := len(lampShows.playing) - 1; i >= 0; i-- {
+		if state, ok := lampShows.playing[i].lamps[lampID]; ok {
+			return state, true
+		}
+	}
+	return Off, false
+}
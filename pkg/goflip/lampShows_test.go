@@ -0,0 +1,26 @@
+package goflip
+
+import "testing"
+
+func TestRegisterLampShowGroupsFirst(t *testing.T) {
+	//the step's lamps are a map, so register it enough times to see a random order
+	for i := 0; i < 20; i++ {
+		show := &LampShow{
+			Name:   "groupsFirst",
+			Groups: map[string][]int{"lanes": {1, 2, 3}, "all": {1, 2, 3, 4}},
+			Steps: []LampShowStep{
+				{Lamps: map[string]string{"lanes": "off", "1": "on", "all": "slow", "4": "fast"}, Hold: 1},
+			},
+		}
+		if err := RegisterLampShow(show); err != nil {
+			t.Fatal(err)
+		}
+
+		want := map[int]int{1: On, 2: Off, 3: Off, 4: FastBlink}
+		for id, state := range want {
+			if got := show.steps[0][id]; got != state {
+				t.Fatalf("lamp %d = %d, want %d", id, got, state)
+			}
+		}
+	}
+}
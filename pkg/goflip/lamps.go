@@ -0,0 +1,261 @@
+package goflip
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+lamps keeps a registry of the lamps in the machine, so that rules, lamp shows and the
+web interface can use names instead of lamp numbers. Each lamp can have tags (for
+example "insert", "bonus" or "gi") and be put in ordered groups (the bonus ladder, the
+lane lamps..). Groups have operations for the common lamp effects:
+
+	SetAll - every lamp in the group to the same state
+	Fill   - the first n lamps on, the rest off (bonus ladders, progress lamps)
+	Rotate - move the lamp states one place (lane change)
+	Chase  - a single lamp lit, moving along the group
+
+Lamps are registered at Init. The group operations set the base game layer, so modes
+and lamp shows still show over them.
+*/
+
+// ErrUnknownLamp is returned when a lamp or lamp group name is not registered
+var ErrUnknownLamp = errors.New("unknown lamp")
+
+// LampInfo describes a registered lamp
+type LampInfo struct {
+	ID    int
+	Name  string
+	Tags  []string
+	State int //state showing, filled in by Lamps
+}
+
+// LampGroup is an ordered group of lamps
+type LampGroup struct {
+	Name  string
+	Lamps []int
+
+	mu        sync.Mutex
+	chaseStep int
+}
+
+type lampRegistryState struct {
+	mu     sync.Mutex
+	lamps  map[int]*LampInfo
+	names  map[string]int
+	groups map[string]*LampGroup
+}
+
+var lampRegistry = lampRegistryState{
+	lamps:  make(map[int]*LampInfo),
+	names:  make(map[string]int),
+	groups: make(map[string]*LampGroup),
+}
+
+// RegisterLamp gives the lamp a name and tags. Registering the same lamp again replaces its name and tags
+func RegisterLamp(lampID int, name string, tags ...string) error {
+	lampRegistry.mu.Lock()
+	defer lampRegistry.mu.Unlock()
+
+	if id, ok := lampRegistry.names[name]; ok && id != lampID {
+		return fmt.Errorf("lamp name %s is already used by lamp %d", name, id)
+	}
+
+	if old, ok := lampRegistry.lamps[lampID]; ok {
+		delete(lampRegistry.names, old.Name)
+	}
+
+	lampRegistry.lamps[lampID] = &LampInfo{ID: lampID, Name: name, Tags: tags}
+	lampRegistry.names[name] = lampID
+	return nil
+}
+
+// LampID returns the ID of the named lamp
+func LampID(name string) (int, error) {
+	lampRegistry.mu.Lock()
+	defer lampRegistry.mu.Unlock()
+
+	id, ok := lampRegistry.names[name]
+	if !ok {
+		return 0, fmt.Errorf("%w %s", ErrUnknownLamp, name)
+	}
+	return id, nil
+}
+
+// LampIDs returns the IDs of the named lamps, in the same order
+func LampIDs(names ...string) ([]int, error) {
+	ids := make([]int, 0, len(names))
+	for _, n := range names {
+		id, err := LampID(n)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// LampName returns the name of the lamp, or the lamp number if it isn't registered
+func LampName(lampID int) string {
+	lampRegistry.mu.Lock()
+	defer lampRegistry.mu.Unlock()
+
+	if l, ok := lampRegistry.lamps[lampID]; ok {
+		return l.Name
+	}
+	return fmt.Sprintf("%d", lampID)
+}
+
+// LampsTagged returns the IDs of the lamps with the tag, lowest ID first
+func LampsTagged(tag string) []int {
+	lampRegistry.mu.Lock()
+	defer lampRegistry.mu.Unlock()
+
+	var ids []int
+	for id, l := range lampRegistry.lamps {
+		for _, t := range l.Tags {
+			if t == tag {
+				ids = append(ids, id)
+				break
+			}
+		}
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// Lamps returns all of the registered lamps with the state they are showing, lowest ID first
+func Lamps() []LampInfo {
+	lampRegistry.mu.Lock()
+	lamps := make([]LampInfo, 0, len(lampRegistry.lamps))
+	for _, l := range lampRegistry.lamps {
+		lamps = append(lamps, LampInfo{ID: l.ID, Name: l.Name, Tags: append([]string(nil), l.Tags...)})
+	}
+	lampRegistry.mu.Unlock()
+
+	sort.Slice(lamps, func(i, j int) bool { return lamps[i].ID < lamps[j].ID })
+	for i := range lamps {
+		lamps[i].State = GetLampState(lamps[i].ID)
+	}
+	return lamps
+}
+
+// SetNamedLamp sets the base game state of the named lamp
+func SetNamedLamp(name string, state int) error {
+	id, err := LampID(name)
+	if err != nil {
+		return err
+	}
+	SetLampState(id, state)
+	return nil
+}
+
+// RegisterLampGroup adds an ordered group of lamps. A group with the same name is replaced
+func RegisterLampGroup(name string, lamps ...int) *LampGroup {
+	grp := &LampGroup{Name: name, Lamps: lamps}
+
+	lampRegistry.mu.Lock()
+	defer lampRegistry.mu.Unlock()
+	lampRegistry.groups[name] = grp
+	return grp
+}
+
+// GetLampGroup returns the named lamp group, nil if there isn't one
+func GetLampGroup(name string) *LampGroup {
+	lampRegistry.mu.Lock()
+	defer lampRegistry.mu.Unlock()
+	return lampRegistry.groups[name]
+}
+
+// resolveLampName returns the IDs for a registered lamp group or lamp name
+func resolveLampName(name string) ([]int, bool) {
+	lampRegistry.mu.Lock()
+	defer lampRegistry.mu.Unlock()
+
+	if grp, ok := lampRegistry.groups[name]; ok {
+		return append([]int(nil), grp.Lamps...), true
+	}
+	if id, ok := lampRegistry.names[name]; ok {
+		return []int{id}, true
+	}
+	return nil, false
+}
+
+// SetAll sets every lamp in the group to the state
+func (grp *LampGroup) SetAll(state int) {
+	for _, l := range grp.Lamps {
+		SetLampState(l, state)
+	}
+}
+
+// Fill sets the first n lamps of the group to the state, and turns the rest off
+func (grp *LampGroup) Fill(n int, state int) {
+	for i, l := range grp.Lamps {
+		if i < n {
+			SetLampState(l, state)
+		} else {
+			SetLampState(l, Off)
+		}
+	}
+}
+
+// Rotate moves the state of every lamp in the group one place. Positive is to the right (higher index), negative to the left
+func (grp *LampGroup) Rotate(direction int) {
+	n := len(grp.Lamps)
+	if n < 2 || direction == 0 {
+		return
+	}
+
+	states := make([]int, n)
+	for i, l := range grp.Lamps {
+		states[i] = baseLampState(l)
+	}
+
+	for i, l := range grp.Lamps {
+		if direction > 0 {
+			SetLampState(l, states[(i+n-1)%n])
+		} else {
+			SetLampState(l, states[(i+1)%n])
+		}
+	}
+}
+
+// Chase lights one lamp of the group at a time in the state, moving to the next lamp every step
+// until StopChase is called or the scope ends
+func (grp *LampGroup) Chase(scope TimerScope, step time.Duration, state int) {
+	if len(grp.Lamps) == 0 {
+		return
+	}
+
+	grp.mu.Lock()
+	grp.chaseStep = 0
+	grp.mu.Unlock()
+
+	grp.Fill(0, Off)
+	SetLampState(grp.Lamps[0], state)
+
+	Every(grp.chaseTimer(), scope, step, func() {
+		grp.mu.Lock()
+		last := grp.chaseStep
+		grp.chaseStep = (grp.chaseStep + 1) % len(grp.Lamps)
+		next := grp.chaseStep
+		grp.mu.Unlock()
+
+		SetLampState(grp.Lamps[last], Off)
+		SetLampState(grp.Lamps[next], state)
+	})
+}
+
+// StopChase stops the chase and turns the group's lamps off
+func (grp *LampGroup) StopChase() {
+	CancelTimer(grp.chaseTimer())
+	grp.SetAll(Off)
+}
+
+func (grp *LampGroup) chaseTimer() string {
+	return "lampGroup:" + grp.Name + ":chase"
+}
@@ -0,0 +1,116 @@
+package goflip
+
+import (
+	"math/rand"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+match runs the match sequence at GameOver. A random value of 00 through 90 is picked
+and spun up on the match display. Any player whose score ends in the same last two
+digits is awarded a credit (with the knocker).
+*/
+
+const (
+	matchSteps     = 10 //possible match values, 00, 10, 20 ... 90
+	matchSpinCount = 12 //number of values flashed during the match animation
+	matchSpinMS    = 120
+)
+
+// MatchConfig holds the operator settings for the match feature
+type MatchConfig struct {
+	Enabled    bool
+	Percentage int  //chance (0-100) that the match is forced to hit a player's score. 0 leaves it up to chance
+	Sound      byte //sound played for every step of the match animation. 0 for none
+}
+
+// MatchObserver can optionally be implemented by an Observer to be told the match outcome
+type MatchObserver interface {
+	Matched(value int, players []int) //value is the match value (0-90), players are the player numbers that matched
+}
+
+var matchRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+
+// runMatch is called at GameOver to run the match sequence against the final scores, one per player
+func runMatch(scores []int64) {
+	g := GetMachine()
+	if !g.MatchConfig.Enabled || len(scores) == 0 {
+		return
+	}
+
+	value := pickMatch(scores)
+
+	//animation, spin through random values before landing on the match
+	for i := 0; i < matchSpinCount; i++ {
+		SetMatchDisp(int8(matchRand.Intn(matchSteps) * 10))
+		if g.MatchConfig.Sound > 0 {
+			PlaySound(g.MatchConfig.Sound)
+		}
+		time.Sleep(time.Millisecond * time.Duration(matchSpinMS+i*20))
+	}
+
+	g.matchValue = value
+	SetMatchDisp(int8(value))
+
+	var winners []int
+	for i, score := range scores {
+		if int(score%100) == value {
+			winners = append(winners, i+1)
+		}
+	}
+
+	log.Infof("match: value is %02d, matched players: %v", value, winners)
+
+	for range winners {
+		AddCredits(1)
+		FireKnocker()
+		time.Sleep(time.Millisecond * 300) //give the knocker time to recycle
+	}
+
+	for _, f := range g.Observers {
+		if o, ok := f.(MatchObserver); ok {
+			o.Matched(value, winners)
+		}
+	}
+
+	SendStats()
+}
+
+// pickMatch chooses the match value for the scores, honoring the configured match percentage
+func pickMatch(scores []int64) int {
+	g := GetMachine()
+
+	var hits, misses []int
+	for v := 0; v < matchSteps; v++ {
+		value := v * 10
+		matched := false
+		for _, score := range scores {
+			if int(score%100) == value {
+				matched = true
+				break
+			}
+		}
+
+		if matched {
+			hits = append(hits, value)
+		} else {
+			misses = append(misses, value)
+		}
+	}
+
+	if g.MatchConfig.Percentage <= 0 {
+		return matchRand.Intn(matchSteps) * 10
+	}
+
+	if matchRand.Intn(100) < g.MatchConfig.Percentage && len(hits) > 0 {
+		return hits[matchRand.Intn(len(hits))]
+	}
+
+	if len(misses) > 0 {
+		return misses[matchRand.Intn(len(misses))]
+	}
+
+	return hits[matchRand.Intn(len(hits))]
+}
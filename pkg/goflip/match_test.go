@@ -0,0 +1,74 @@
+package goflip
+
+import "testing"
+
+func TestPickMatch(t *testing.T) {
+	g := GetMachine()
+	saved := g.MatchConfig
+	defer func() { g.MatchConfig = saved }()
+
+	tests := []struct {
+		name       string
+		percentage int
+		scores     []int64
+		want       []int //any of these is a pass
+	}{
+		{"always hit one player", 100, []int64{12340}, []int{40}},
+		{"always hit either player", 100, []int64{1020, 5570}, []int{20, 70}},
+		{"hundreds ignored", 100, []int64{99900}, []int{0}},
+		{"no score can match", 100, []int64{1005}, []int{0, 10, 20, 30, 40, 50, 60, 70, 80, 90}},
+		{"left to chance", 0, []int64{1230}, []int{0, 10, 20, 30, 40, 50, 60, 70, 80, 90}},
+	}
+
+	for _, tt := range tests {
+		g.MatchConfig = MatchConfig{Enabled: true, Percentage: tt.percentage}
+		for i := 0; i < 50; i++ {
+			got := pickMatch(tt.scores)
+			if !containsInt(tt.want, got) {
+				t.Errorf("%s: pickMatch(%v) = %d, want one of %v", tt.name, tt.scores, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestPickMatchLandsOnPlayer(t *testing.T) {
+	g := GetMachine()
+	saved := g.MatchConfig
+	defer func() { g.MatchConfig = saved }()
+
+	var all, allButOne []int64
+	for v := int64(0); v < matchSteps; v++ {
+		all = append(all, 1000+v*10)
+		if v != 5 {
+			allButOne = append(allButOne, 2000+v*10)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		percentage int
+		scores     []int64
+	}{
+		//with every value matching a player, even a miss has to land on a player's score
+		{"every value matched", 1, all},
+		{"always hit", 100, allButOne},
+	}
+
+	for _, tt := range tests {
+		g.MatchConfig = MatchConfig{Enabled: true, Percentage: tt.percentage}
+		for i := 0; i < 50; i++ {
+			got := pickMatch(tt.scores)
+
+			matched := false
+			for _, score := range tt.scores {
+				if int(score%100) == got {
+					matched = true
+				}
+			}
+			if !matched {
+				t.Fatalf("%s: pickMatch() = %d, which doesn't match any of %v", tt.name, got, tt.scores)
+			}
+		}
+	}
+}
@@ -0,0 +1,334 @@
+package goflip
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+modes lets the rules of a game be broken up into Modes that are only active part of the
+time (multiball, hurry ups, wizard modes..). Modes are registered at Init, and are
+started by a trigger (game start, ball start, a switch) or by calling StartMode.
+
+Active modes get switch events in priority order (highest first) before the Observers.
+A mode's SwitchHandler can return true to keep the switch from going to lower priority
+modes. Each mode has its own lamps and displays, which show over the base game (and
+lower priority modes) while the mode is active, and its own scheduler timers. All of
+these are cleaned up when the mode stops. Modes are always stopped at GameOver, and at
+the end of the ball if StopAtBallEnd is set.
+*/
+
+// ModeTrigger is what starts a Mode
+type ModeTrigger int
+
+const (
+	ModeStartManual ModeTrigger = iota //only started by StartMode
+	ModeStartGame                      //started at GameStart
+	ModeStartBall                      //started at every PlayerUp
+	ModeStartSwitch                    //started when any of the StartSwitches are pressed during a game
+)
+
+// ErrUnknownMode is returned when a mode name is not registered
+var ErrUnknownMode = errors.New("unknown mode")
+
+// Mode is a set of rules that is only active part of the time
+type Mode struct {
+	Name          string
+	Priority      int //higher priority modes get switches first, and their lamps and displays show over lower ones
+	StartOn       ModeTrigger
+	StartSwitches []int //for ModeStartSwitch
+	StopSwitches  []int //mode is stopped when any of these are pressed
+	StopAtBallEnd bool  //stop the mode when the ball drains. All modes are stopped at GameOver
+
+	ScoreMultiplier int //all points scored while the mode is active are multiplied by this. 0 or 1 for none
+
+	OnStart       func(m *Mode)
+	OnStop        func(m *Mode)
+	SwitchHandler func(m *Mode, sw SwitchEvent) bool //return true if the switch should not go to lower priority modes
+
+	mu       sync.Mutex
+	active   bool
+	lamps    map[int]int
+	displays map[int]int64
+}
+
+var (
+	modes   []*Mode
+	modesMu sync.Mutex
+)
+
+// RegisterMode adds a mode so that it can be started
+func RegisterMode(m *Mode) {
+	modesMu.Lock()
+	defer modesMu.Unlock()
+
+	m.lamps = make(map[int]int)
+	m.displays = make(map[int]int64)
+	modes = append(modes, m)
+}
+
+// GetMode returns the registered mode with the name passed in, nil if there isn't one
+func GetMode(name string) *Mode {
+	modesMu.Lock()
+	defer modesMu.Unlock()
+
+	for _, m := range modes {
+		if m.Name == name {
+			return m
+		}
+	}
+	return nil
+}
+
+// StartMode starts the named mode. Starting a mode that is already active does nothing
+func StartMode(name string) error {
+	m := GetMode(name)
+	if m == nil {
+		return ErrUnknownMode
+	}
+	m.start()
+	return nil
+}
+
+// StopMode stops the named mode
+func StopMode(name string) error {
+	m := GetMode(name)
+	if m == nil {
+		return ErrUnknownMode
+	}
+	m.stop()
+	return nil
+}
+
+// IsModeActive returns true if the named mode is running
+func IsModeActive(name string) bool {
+	m := GetMode(name)
+	return m != nil && m.IsActive()
+}
+
+// ActiveModes returns the running modes, highest priority first
+func ActiveModes() []*Mode {
+	modesMu.Lock()
+	defer modesMu.Unlock()
+
+	var active []*Mode
+	for _, m := range modes {
+		if m.IsActive() {
+			active = append(active, m)
+		}
+	}
+
+	sort.SliceStable(active, func(i, j int) bool { return active[i].Priority > active[j].Priority })
+	return active
+}
+
+// IsActive returns true if the mode is running
+func (m *Mode) IsActive() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.active
+}
+
+func (m *Mode) start() {
+	m.mu.Lock()
+	if m.active {
+		m.mu.Unlock()
+		return
+	}
+	m.active = true
+	m.mu.Unlock()
+
+	log.Debugf("modes: starting %s", m.Name)
+	if m.OnStart != nil {
+		m.OnStart(m)
+	}
+}
+
+func (m *Mode) stop() {
+	m.mu.Lock()
+	if !m.active {
+		m.mu.Unlock()
+		return
+	}
+	m.active = false
+
+	lamps := make([]int, 0, len(m.lamps))
+	for l := range m.lamps {
+		lamps = append(lamps, l)
+		delete(m.lamps, l)
+	}
+
+	displays := make([]int, 0, len(m.displays))
+	for d := range m.displays {
+		displays = append(displays, d)
+		delete(m.displays, d)
+	}
+	m.mu.Unlock()
+
+	log.Debugf("modes: stopping %s", m.Name)
+	cancelModeTimers(m)
+
+	if m.OnStop != nil {
+		m.OnStop(m)
+	}
+
+	for _, l := range lamps {
+		refreshLamp(l)
+	}
+	for _, d := range displays {
+		refreshDisplay(d)
+	}
+}
+
+// SetLamp sets the state of a lamp for this mode. It shows over the base game lamp state while the mode is active
+func (m *Mode) SetLamp(lampID int, state int) {
+	m.mu.Lock()
+	if !m.active {
+		m.mu.Unlock()
+		return
+	}
+	m.lamps[lampID] = state
+	m.mu.Unlock()
+
+	refreshLamp(lampID)
+}
+
+// ClearLamp gives control of the lamp back to lower priority modes and the base game
+func (m *Mode) ClearLamp(lampID int) {
+	m.mu.Lock()
+	delete(m.lamps, lampID)
+	m.mu.Unlock()
+
+	refreshLamp(lampID)
+}
+
+// SetDisplay shows value on the display while the mode is active, instead of the player's score
+func (m *Mode) SetDisplay(display int, value int64) {
+	m.mu.Lock()
+	if !m.active {
+		m.mu.Unlock()
+		return
+	}
+	m.displays[display] = value
+	m.mu.Unlock()
+
+	refreshDisplay(display)
+}
+
+// ClearDisplay gives the display back to lower priority modes and the base game
+func (m *Mode) ClearDisplay(display int) {
+	m.mu.Lock()
+	delete(m.displays, display)
+	m.mu.Unlock()
+
+	refreshDisplay(display)
+}
+
+// After calls fn once after d, unless the mode is stopped first. A timer with the same name replaces the last one
+func (m *Mode) After(name string, d time.Duration, fn func()) *Timer {
+	if !m.IsActive() {
+		return nil
+	}
+	return schedule(m.timerName(name), ScopeMode, m, d, 0, fn)
+}
+
+// Every calls fn every d until the mode is stopped or the timer is cancelled
+func (m *Mode) Every(name string, d time.Duration, fn func()) *Timer {
+	if !m.IsActive() {
+		return nil
+	}
+	return schedule(m.timerName(name), ScopeMode, m, d, d, fn)
+}
+
+// CancelTimer stops the named mode timer
+func (m *Mode) CancelTimer(name string) {
+	CancelTimer(m.timerName(name))
+}
+
+func (m *Mode) timerName(name string) string {
+	return "mode:" + m.Name + ":" + name
+}
+
+// modeLampState returns the lamp state from the highest priority mode that has set it
+func modeLampState(lampID int) (int, bool) {
+	for _, m := range ActiveModes() {
+		m.mu.Lock()
+		state, ok := m.lamps[lampID]
+		m.mu.Unlock()
+		if ok {
+			return state, true
+		}
+	}
+	return Off, false
+}
+
+// modeDisplay returns the display value from the highest priority mode that has set it
+func modeDisplay(display int) (int64, bool) {
+	for _, m := range ActiveModes() {
+		m.mu.Lock()
+		value, ok := m.displays[display]
+		m.mu.Unlock()
+		if ok {
+			return value, true
+		}
+	}
+	return 0, false
+}
+
+// startModes starts all of the registered modes with the trigger passed in
+func startModes(trigger ModeTrigger) {
+	modesMu.Lock()
+	var toStart []*Mode
+	for _, m := range modes {
+		if m.StartOn == trigger {
+			toStart = append(toStart, m)
+		}
+	}
+	modesMu.Unlock()
+
+	for _, m := range toStart {
+		m.start()
+	}
+}
+
+// stopModes stops the active modes. If ballEnd is true, only the modes that stop at the end of the ball
+func stopModes(ballEnd bool) {
+	for _, m := range ActiveModes() {
+		if !ballEnd || m.StopAtBallEnd {
+			m.stop()
+		}
+	}
+}
+
+// modeSwitchHandler passes the switch event to the active modes, and starts or stops modes from their switches
+func modeSwitchHandler(sw SwitchEvent) {
+	if sw.Pressed {
+		modesMu.Lock()
+		var toStart []*Mode
+		for _, m := range modes {
+			if m.StartOn == ModeStartSwitch && containsInt(m.StartSwitches, sw.SwitchID) {
+				toStart = append(toStart, m)
+			}
+		}
+		modesMu.Unlock()
+
+		for _, m := range toStart {
+			m.start()
+		}
+	}
+
+	for _, m := range ActiveModes() {
+		if sw.Pressed && containsInt(m.StopSwitches, sw.SwitchID) {
+			m.stop()
+			continue
+		}
+
+		if m.SwitchHandler != nil && m.SwitchHandler(m, sw) {
+			return
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package goflip
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+persist saves and loads the data goflip keeps between power cycles (recent scores,
+high scores..) as json files in GoFlip.DataPath. If DataPath is empty, nothing is
+saved.
+*/
+
+// saveData writes v as json to the file name in DataPath
+func saveData(name string, v interface{}) error {
+	g := GetMachine()
+	if g.DataPath == "" {
+		return nil
+	}
+
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(g.DataPath, 0755); err != nil {
+		return err
+	}
+
+	//write to a temp file first so that a power loss doesn't leave a partial file
+	path := filepath.Join(g.DataPath, name)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// loadData reads the json file name in DataPath into v. A missing file is not an error, v is left as is
+func loadData(name string, v interface{}) error {
+	g := GetMachine()
+	if g.DataPath == "" {
+		return nil
+	}
+
+	b, err := os.ReadFile(filepath.Join(g.DataPath, name))
+	if errors.Is(err, os.ErrNotExist) {
+		log.Debugf("persist: %s not found, using defaults", name)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, v)
+}
@@ -0,0 +1,226 @@
+package goflip
+
+import (
+	"encoding/json"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+playerVars is a per-player variable store. Each player in the game has their own
+PlayerVars, and CurrentPlayerVars always returns the one for the player that is up,
+so rules code does not need to save and restore its own state between balls.
+The stores are reset at GameStart.
+
+The lamps listed in GoFlip.PlayerLamps are saved into the store at PlayerEnd and
+restored at PlayerUp, so each player sees their own lamp progress.
+*/
+
+// PlayerVarObserver can optionally be implemented by an Observer to be told when a player variable changes
+type PlayerVarObserver interface {
+	PlayerVarChanged(playerID int, name string, value interface{})
+}
+
+// PlayerVars holds the variables for a single player
+type PlayerVars struct {
+	mu       sync.Mutex
+	playerID int
+
+	Ints    map[string]int
+	Flags   map[string]bool
+	Strings map[string]string
+	Lamps   map[int]int //lamp memory, lampID to lamp state
+}
+
+func newPlayerVars(playerID int) *PlayerVars {
+	return &PlayerVars{
+		playerID: playerID,
+		Ints:     make(map[string]int),
+		Flags:    make(map[string]bool),
+		Strings:  make(map[string]string),
+		Lamps:    make(map[int]int),
+	}
+}
+
+// CurrentPlayerVars returns the variables of the player that is up. If no player is up, an empty
+// store is returned that is not saved
+func CurrentPlayerVars() *PlayerVars {
+	g := GetMachine()
+	return PlayerVarsFor(g.CurrentPlayer)
+}
+
+// PlayerVarsFor returns the variables for the player number passed in (1 based)
+func PlayerVarsFor(playerID int) *PlayerVars {
+	g := GetMachine()
+
+	g.playerVarsMu.Lock()
+	defer g.playerVarsMu.Unlock()
+
+	if playerID < 1 || playerID > len(g.playerVars) {
+		return newPlayerVars(0)
+	}
+	return g.playerVars[playerID-1]
+}
+
+// resetPlayerVars is called at GameStart to give every player an empty store
+func resetPlayerVars() {
+	g := GetMachine()
+
+	g.playerVarsMu.Lock()
+	defer g.playerVarsMu.Unlock()
+
+	g.playerVars = make([]*PlayerVars, g.MaxPlayers)
+	for i := range g.playerVars {
+		g.playerVars[i] = newPlayerVars(i + 1)
+	}
+}
+
+// MarshalPlayerVars serialises all of the player variables for the game in progress (for crash recovery)
+func MarshalPlayerVars() ([]byte, error) {
+	g := GetMachine()
+
+	g.playerVarsMu.Lock()
+	defer g.playerVarsMu.Unlock()
+
+	for _, p := range g.playerVars {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+	}
+
+	return json.Marshal(g.playerVars)
+}
+
+// UnmarshalPlayerVars restores the player variables saved with MarshalPlayerVars
+func UnmarshalPlayerVars(data []byte) error {
+	var vars []*PlayerVars
+	if err := json.Unmarshal(data, &vars); err != nil {
+		return err
+	}
+
+	for i, p := range vars {
+		restored := newPlayerVars(i + 1)
+		if p == nil {
+			//saved as null, the player starts with no variables
+			vars[i] = restored
+			continue
+		}
+		for k, v := range p.Ints {
+			restored.Ints[k] = v
+		}
+		for k, v := range p.Flags {
+			restored.Flags[k] = v
+		}
+		for k, v := range p.Strings {
+			restored.Strings[k] = v
+		}
+		for k, v := range p.Lamps {
+			restored.Lamps[k] = v
+		}
+		vars[i] = restored
+	}
+
+	g := GetMachine()
+	g.playerVarsMu.Lock()
+	g.playerVars = vars
+	g.playerVarsMu.Unlock()
+	return nil
+}
+
+// GetInt returns the named counter, 0 if it was never set
+func (p *PlayerVars) GetInt(name string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.Ints[name]
+}
+
+// SetInt sets the named counter
+func (p *PlayerVars) SetInt(name string, value int) {
+	p.mu.Lock()
+	p.Ints[name] = value
+	p.mu.Unlock()
+
+	p.notify(name, value)
+}
+
+// AddInt adds to the named counter and returns the new value
+func (p *PlayerVars) AddInt(name string, add int) int {
+	p.mu.Lock()
+	p.Ints[name] += add
+	value := p.Ints[name]
+	p.mu.Unlock()
+
+	p.notify(name, value)
+	return value
+}
+
+// GetFlag returns the named flag, false if it was never set
+func (p *PlayerVars) GetFlag(name string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.Flags[name]
+}
+
+// SetFlag sets the named flag
+func (p *PlayerVars) SetFlag(name string, value bool) {
+	p.mu.Lock()
+	p.Flags[name] = value
+	p.mu.Unlock()
+
+	p.notify(name, value)
+}
+
+// GetString returns the named string, empty if it was never set
+func (p *PlayerVars) GetString(name string) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.Strings[name]
+}
+
+// SetString sets the named string
+func (p *PlayerVars) SetString(name string, value string) {
+	p.mu.Lock()
+	p.Strings[name] = value
+	p.mu.Unlock()
+
+	p.notify(name, value)
+}
+
+// SaveLamps stores the current state of the lamps passed in
+func (p *PlayerVars) SaveLamps(lampIDs ...int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, l := range lampIDs {
+		p.Lamps[l] = baseLampState(l)
+	}
+}
+
+// RestoreLamps sets the lamps passed in back to their saved state. Lamps that were never saved are turned off
+func (p *PlayerVars) RestoreLamps(lampIDs ...int) {
+	p.mu.Lock()
+	states := make(map[int]int, len(lampIDs))
+	for _, l := range lampIDs {
+		states[l] = p.Lamps[l]
+	}
+	p.mu.Unlock()
+
+	for _, l := range lampIDs {
+		SetLampState(l, states[l])
+	}
+}
+
+func (p *PlayerVars) notify(name string, value interface{}) {
+	if p.playerID == 0 {
+		return
+	}
+
+	g := GetMachine()
+	log.Debugf("playerVars: player %d %s = %v", p.playerID, name, value)
+
+	for _, f := range g.Observers {
+		if o, ok := f.(PlayerVarObserver); ok {
+			o.PlayerVarChanged(p.playerID, name, value)
+		}
+	}
+}
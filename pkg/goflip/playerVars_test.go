@@ -0,0 +1,34 @@
+package goflip
+
+import "testing"
+
+func TestUnmarshalPlayerVars(t *testing.T) {
+	g := GetMachine()
+	g.playerVarsMu.Lock()
+	saved := g.playerVars
+	g.playerVarsMu.Unlock()
+	defer func() {
+		g.playerVarsMu.Lock()
+		g.playerVars = saved
+		g.playerVarsMu.Unlock()
+	}()
+
+	data := []byte(`[{"Ints": {"ramps": 3}, "Flags": {"lit": true}}, null]`)
+	if err := UnmarshalPlayerVars(data); err != nil {
+		t.Fatal(err)
+	}
+
+	p1 := PlayerVarsFor(1)
+	if p1.GetInt("ramps") != 3 || !p1.GetFlag("lit") {
+		t.Errorf("player 1 = %+v, want ramps 3 and lit", p1)
+	}
+
+	p2 := PlayerVarsFor(2)
+	if p2 == nil {
+		t.Fatal("player 2 saved as null is nil, want an empty store")
+	}
+	if p2.GetInt("ramps") != 0 {
+		t.Errorf("player 2 ramps = %d, want 0", p2.GetInt("ramps"))
+	}
+	p2.SetInt("ramps", 1)
+}
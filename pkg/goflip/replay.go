@@ -0,0 +1,195 @@
+package goflip
+
+import (
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+replay awards specials when a player's score passes the replay levels. The levels are
+either fixed by the operator, or automatically adjusted (auto percentaging) so that
+about ReplayConfig.AutoPercentage percent of the recent games earn a replay. The
+scores of the recent games are saved in DataPath so that the adjusting carries over
+between power cycles.
+*/
+
+// ReplayMode is how the replay levels are set
+type ReplayMode int
+
+const (
+	ReplayOff   ReplayMode = iota
+	ReplayFixed            //ReplayConfig.Levels are used as is
+	ReplayAuto             //levels are calculated from the recent game scores
+)
+
+// SpecialAward is what a player gets for a special or replay
+type SpecialAward int
+
+const (
+	SpecialCredit    SpecialAward = iota //adds a credit and fires the knocker
+	SpecialExtraBall                     //player shoots again
+	SpecialPoints                        //awards ReplayConfig.SpecialPoints
+)
+
+const replayFile = "replay.json"
+
+// ReplayConfig holds the operator settings for replays and specials
+type ReplayConfig struct {
+	Mode           ReplayMode
+	Levels         []int64      //fixed replay levels, lowest first
+	Award          SpecialAward //what is awarded for reaching a replay level
+	SpecialPoints  int          //points awarded for SpecialPoints
+	AutoPercentage int          //percentage of games that should earn the first replay
+	AutoGames      int          //number of recent game scores used for the auto percentaging
+	AutoLevels     int          //number of replay levels. Each level after the first is a multiple of the first
+	AutoStart      int64        //first replay level used until enough games have been played
+	AutoRound      int64        //auto levels are rounded to this (10000 for example)
+}
+
+// ReplayObserver can optionally be implemented by an Observer to be told when a replay or special is awarded
+type ReplayObserver interface {
+	SpecialAwarded(playerID int, award SpecialAward)
+}
+
+type replayHistory struct {
+	Scores []int64
+}
+
+// AwardSpecial awards the special to the current player
+func AwardSpecial(award SpecialAward) {
+	g := GetMachine()
+
+	log.Infof("replay: awarding special %d to player %d", award, g.CurrentPlayer)
+
+	switch award {
+	case SpecialCredit:
+		AddCredits(1)
+		FireKnocker()
+	case SpecialExtraBall:
+		g.ExtraBall = true
+	case SpecialPoints:
+		AddScore(g.ReplayConfig.SpecialPoints)
+	}
+
+	for _, f := range g.Observers {
+		if o, ok := f.(ReplayObserver); ok {
+			o.SpecialAwarded(g.CurrentPlayer, award)
+		}
+	}
+}
+
+// ReplayLevels returns the replay levels in use
+func ReplayLevels() []int64 {
+	g := GetMachine()
+	cfg := g.ReplayConfig
+
+	switch cfg.Mode {
+	case ReplayFixed:
+		return cfg.Levels
+	case ReplayAuto:
+		first := autoReplayLevel()
+		levels := make([]int64, cfg.AutoLevels)
+		for i := range levels {
+			levels[i] = first * int64(i+1)
+		}
+		return levels
+	}
+	return nil
+}
+
+// ReplaysEarned returns the number of replay levels the player has passed this game
+func ReplaysEarned(playerID int) int {
+	g := GetMachine()
+	if playerID < 1 || playerID > len(g.replaysEarned) {
+		return 0
+	}
+	return g.replaysEarned[playerID-1]
+}
+
+// checkReplay is called after every score change for the current player
+func checkReplay() {
+	g := GetMachine()
+	p := g.CurrentPlayer
+	if g.ReplayConfig.Mode == ReplayOff || p < 1 || p > len(g.replaysEarned) {
+		return
+	}
+
+	levels := ReplayLevels()
+	score := PlayerScore(p)
+
+	for g.replaysEarned[p-1] < len(levels) && score >= levels[g.replaysEarned[p-1]] {
+		g.replaysEarned[p-1]++
+		log.Infof("replay: player %d passed replay level %d", p, g.replaysEarned[p-1])
+		AwardSpecial(g.ReplayConfig.Award)
+	}
+}
+
+// autoReplayLevel calculates the first replay level from the recent game scores
+func autoReplayLevel() int64 {
+	g := GetMachine()
+	cfg := g.ReplayConfig
+
+	g.replayMu.Lock()
+	scores := append([]int64(nil), g.replayHistory.Scores...)
+	g.replayMu.Unlock()
+
+	if len(scores) < cfg.AutoGames || cfg.AutoPercentage <= 0 {
+		return cfg.AutoStart
+	}
+
+	sort.Slice(scores, func(i, j int) bool { return scores[i] > scores[j] })
+
+	//the score that AutoPercentage of the games reached
+	idx := len(scores) * cfg.AutoPercentage / 100
+	if idx >= len(scores) {
+		idx = len(scores) - 1
+	}
+	level := scores[idx]
+
+	if cfg.AutoRound > 0 {
+		level = ((level + cfg.AutoRound - 1) / cfg.AutoRound) * cfg.AutoRound
+	}
+	if level <= 0 {
+		return cfg.AutoStart
+	}
+	return level
+}
+
+// resetReplays is called at GameStart
+func resetReplays() {
+	g := GetMachine()
+	g.replaysEarned = make([]int, g.MaxPlayers)
+}
+
+// recordReplayScores is called at GameOver to add the game's scores to the auto percentaging history
+func recordReplayScores() {
+	g := GetMachine()
+
+	g.replayMu.Lock()
+	defer g.replayMu.Unlock()
+
+	for p := 1; p <= g.NumOfPlayers; p++ {
+		g.replayHistory.Scores = append(g.replayHistory.Scores, PlayerScore(p))
+	}
+
+	if max := g.ReplayConfig.AutoGames; max > 0 && len(g.replayHistory.Scores) > max {
+		g.replayHistory.Scores = g.replayHistory.Scores[len(g.replayHistory.Scores)-max:]
+	}
+
+	if err := saveData(replayFile, &g.replayHistory); err != nil {
+		log.Errorf("replay: unable to save recent scores: %v", err)
+	}
+}
+
+// loadReplayHistory is called at Init to load the recent game scores
+func loadReplayHistory() {
+	g := GetMachine()
+
+	g.replayMu.Lock()
+	defer g.replayMu.Unlock()
+
+	if err := loadData(replayFile, &g.replayHistory); err != nil {
+		log.Errorf("replay: unable to load recent scores: %v", err)
+	}
+}
@@ -0,0 +1,230 @@
+package goflip
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+rgbLamps drives lamps that are LEDs on an addressable LED string, through the LED
+controller board (the arduino that answers 'd' when connecting). A lamp is made an
+RGB lamp with RegisterRGBLamp, giving its position on the string and its colour.
+
+RGB lamps still use the classic lamp states, through all of the lamp layers, so
+existing rules keep working: Off is dark, On shows the lamp's colour, SlowBlink and
+FastBlink blink the colour, and lamp patterns fade it in and out. On top of that the
+colour can be changed (SetLampColor), faded to a new colour (FadeLampColor), or
+blinked between two colours (BlinkLampColor).
+
+The LED string is redrawn every rgbFrame, and only the LEDs that changed are sent.
+*/
+
+const (
+	rgbFrame     = 20 * time.Millisecond
+	rgbSlowBlink = 500 * time.Millisecond //on time (and off time) for SlowBlink
+	rgbFastBlink = 125 * time.Millisecond //on time (and off time) for FastBlink
+)
+
+// Color is the colour of an RGB lamp
+type Color struct {
+	R uint8
+	G uint8
+	B uint8
+}
+
+var (
+	ColorOff    = Color{0, 0, 0}
+	ColorWhite  = Color{255, 255, 255}
+	ColorRed    = Color{255, 0, 0}
+	ColorGreen  = Color{0, 255, 0}
+	ColorBlue   = Color{0, 0, 255}
+	ColorYellow = Color{255, 255, 0}
+	ColorOrange = Color{255, 128, 0}
+	ColorPurple = Color{128, 0, 255}
+)
+
+type rgbLamp struct {
+	led   int
+	color Color //colour shown for On
+
+	fadeFrom  Color
+	fadeStart time.Time
+	fadeTime  time.Duration
+
+	blinkColor  Color
+	blinkPeriod time.Duration //0 for no colour blink
+
+	sent     Color
+	sentOnce bool
+}
+
+var (
+	rgbLamps   = make(map[int]*rgbLamp)
+	rgbLampsMu sync.Mutex
+)
+
+// RegisterRGBLamp makes the lamp an LED on the LED string, showing color when it is on
+func RegisterRGBLamp(lampID int, led int, color Color) {
+	rgbLampsMu.Lock()
+	defer rgbLampsMu.Unlock()
+	rgbLamps[lampID] = &rgbLamp{led: led, color: color}
+}
+
+// IsRGBLamp returns true if the lamp is an LED on the LED string
+func IsRGBLamp(lampID int) bool {
+	rgbLampsMu.Lock()
+	defer rgbLampsMu.Unlock()
+	_, ok := rgbLamps[lampID]
+	return ok
+}
+
+// SetLampColor changes the colour the lamp shows when it is on
+func SetLampColor(lampID int, color Color) error {
+	return FadeLampColor(lampID, color, 0)
+}
+
+// FadeLampColor fades the colour the lamp shows when it is on to color, over d
+func FadeLampColor(lampID int, color Color, d time.Duration) error {
+	rgbLampsMu.Lock()
+	defer rgbLampsMu.Unlock()
+
+	l, ok := rgbLamps[lampID]
+	if !ok {
+		return fmt.Errorf("lamp %d is not an RGB lamp", lampID)
+	}
+
+	now := time.Now()
+	l.fadeFrom = l.colorAt(now)
+	l.fadeStart = now
+	l.fadeTime = d
+	l.color = color
+	return nil
+}
+
+// BlinkLampColor switches the lamp between its colour and color every period. A period of 0 stops the colour blink
+func BlinkLampColor(lampID int, color Color, period time.Duration) error {
+	rgbLampsMu.Lock()
+	defer rgbLampsMu.Unlock()
+
+	l, ok := rgbLamps[lampID]
+	if !ok {
+		return fmt.Errorf("lamp %d is not an RGB lamp", lampID)
+	}
+
+	l.blinkColor = color
+	l.blinkPeriod = period
+	return nil
+}
+
+// LampColor returns the colour the lamp is showing right now
+func LampColor(lampID int) (Color, error) {
+	state := GetLampState(lampID)
+	now := time.Now()
+	level := lampPatternLevel(lampID, state, now)
+
+	rgbLampsMu.Lock()
+	defer rgbLampsMu.Unlock()
+
+	l, ok := rgbLamps[lampID]
+	if !ok {
+		return ColorOff, fmt.Errorf("lamp %d is not an RGB lamp", lampID)
+	}
+	return l.show(state, level, now), nil
+}
+
+// colorAt returns the on colour at the time passed in, part way through a fade. Called with the lock held
+func (l *rgbLamp) colorAt(now time.Time) Color {
+	if l.fadeTime <= 0 {
+		return l.color
+	}
+
+	done := now.Sub(l.fadeStart)
+	if done >= l.fadeTime {
+		return l.color
+	}
+
+	f := float64(done) / float64(l.fadeTime)
+	mix := func(a, b uint8) uint8 {
+		return uint8(float64(a) + (float64(b)-float64(a))*f)
+	}
+	return Color{mix(l.fadeFrom.R, l.color.R), mix(l.fadeFrom.G, l.color.G), mix(l.fadeFrom.B, l.color.B)}
+}
+
+// show maps the lamp state onto a colour, dimmed to level for lamp patterns. Called with the lock held
+func (l *rgbLamp) show(state int, level float64, now time.Time) Color {
+	color := l.colorAt(now)
+	if l.blinkPeriod > 0 && blinkPhase(now, l.blinkPeriod) {
+		color = l.blinkColor
+	}
+
+	if isLampPattern(state) {
+		return color.scale(level)
+	}
+
+	switch state {
+	case On:
+		return color
+	case SlowBlink:
+		if !blinkPhase(now, rgbSlowBlink) {
+			return color
+		}
+	case FastBlink:
+		if !blinkPhase(now, rgbFastBlink) {
+			return color
+		}
+	}
+	return ColorOff
+}
+
+func (c Color) scale(level float64) Color {
+	return Color{uint8(float64(c.R) * level), uint8(float64(c.G) * level), uint8(float64(c.B) * level)}
+}
+
+// blinkPhase returns true for the second half of each blink. Worked out from the clock so every lamp blinks together
+func blinkPhase(now time.Time, period time.Duration) bool {
+	return (now.UnixNano()/int64(period))%2 == 1
+}
+
+// ledSubscriber redraws the RGB lamps, sending the LEDs that changed to the LED controller
+func ledSubscriber() {
+	g := GetMachine()
+	log.Debugln("Starting LED subscribing")
+
+	g.devices.led.consoleMode = g.ConsoleMode
+
+	for range time.Tick(rgbFrame) {
+		if g.Quitting {
+			return
+		}
+
+		rgbLampsMu.Lock()
+		ids := make([]int, 0, len(rgbLamps))
+		for id := range rgbLamps {
+			ids = append(ids, id)
+		}
+		rgbLampsMu.Unlock()
+
+		now := time.Now()
+		for _, id := range ids {
+			state := GetLampState(id)
+			level := lampPatternLevel(id, state, now)
+
+			rgbLampsMu.Lock()
+			l := rgbLamps[id]
+			color := l.show(state, level, now)
+			changed := !l.sentOnce || color != l.sent
+			l.sent = color
+			l.sentOnce = true
+			rgbLampsMu.Unlock()
+
+			if changed {
+				if err := g.devices.led.SendColorMessage(l.led, color); err != nil {
+					log.Errorf("LED controller: %v", err)
+				}
+			}
+		}
+	}
+}
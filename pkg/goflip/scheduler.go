@@ -0,0 +1,267 @@
+package goflip
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+/*
+scheduler runs the timers for game rules in place of time.Sleep and go routines. Every
+timer has a scope, and is cancelled automatically when the scope ends:
+
+	ScopeBall    - cancelled when the ball drains
+	ScopePlayer  - cancelled when the next player is up (kept through an extra ball)
+	ScopeGame    - cancelled at GameOver
+	ScopeMode    - cancelled when the Mode that started it stops (see Mode.After)
+	ScopeMachine - never cancelled automatically
+
+All timers other than ScopeMachine are paused while the ball is ending (bonus count)
+and in service mode, and pick up where they left off when the next ball starts.
+*/
+
+// TimerScope is how long a timer lives for
+type TimerScope int
+
+const (
+	ScopeMachine TimerScope = iota
+	ScopeGame
+	ScopePlayer
+	ScopeBall
+	ScopeMode
+)
+
+// Timer is a one shot or repeating timer created by the scheduler
+type Timer struct {
+	name      string
+	scope     TimerScope
+	owner     *Mode
+	fn        func()
+	repeat    time.Duration //0 for a one shot
+	timer     *time.Timer
+	deadline  time.Time
+	remaining time.Duration //set while paused
+	paused    bool
+}
+
+type scheduler struct {
+	mu      sync.Mutex
+	timers  map[string]*Timer
+	paused  bool
+	counter int //used to name the unnamed delays
+}
+
+var sched = scheduler{timers: make(map[string]*Timer)}
+
+// After calls fn once after d. A timer with the same name replaces the existing one
+func After(name string, scope TimerScope, d time.Duration, fn func()) *Timer {
+	return schedule(name, scope, nil, d, 0, fn)
+}
+
+// Every calls fn every d until the timer is cancelled or its scope ends
+func Every(name string, scope TimerScope, d time.Duration, fn func()) *Timer {
+	return schedule(name, scope, nil, d, d, fn)
+}
+
+// Delay calls fn once after d. Unlike After, the delay has no name so it never replaces another timer
+func Delay(scope TimerScope, d time.Duration, fn func()) *Timer {
+	sched.mu.Lock()
+	sched.counter++
+	name := fmt.Sprintf("delay-%d", sched.counter)
+	sched.mu.Unlock()
+
+	return schedule(name, scope, nil, d, 0, fn)
+}
+
+// CancelTimer cancels the named timer
+func CancelTimer(name string) {
+	sched.mu.Lock()
+	defer sched.mu.Unlock()
+
+	if t, ok := sched.timers[name]; ok {
+		t.stop()
+	}
+}
+
+// CancelScope cancels all of the timers in the scope
+func CancelScope(scope TimerScope) {
+	sched.mu.Lock()
+	defer sched.mu.Unlock()
+
+	for _, t := range sched.timers {
+		if t.scope == scope {
+			t.stop()
+		}
+	}
+}
+
+// PauseTimers pauses all of the timers except for ScopeMachine
+func PauseTimers() {
+	sched.mu.Lock()
+	defer sched.mu.Unlock()
+
+	if sched.paused {
+		return
+	}
+	sched.paused = true
+
+	for _, t := range sched.timers {
+		t.pause()
+	}
+}
+
+// ResumeTimers starts the paused timers back up
+func ResumeTimers() {
+	sched.mu.Lock()
+	defer sched.mu.Unlock()
+
+	if !sched.paused {
+		return
+	}
+	sched.paused = false
+
+	for _, t := range sched.timers {
+		t.resume()
+	}
+}
+
+// IsTimerActive returns true if the named timer is scheduled
+func IsTimerActive(name string) bool {
+	sched.mu.Lock()
+	defer sched.mu.Unlock()
+
+	_, ok := sched.timers[name]
+	return ok
+}
+
+// Cancel stops the timer from being called
+func (t *Timer) Cancel() {
+	sched.mu.Lock()
+	defer sched.mu.Unlock()
+
+	if sched.timers[t.name] == t {
+		t.stop()
+	}
+}
+
+// Remaining returns the time left before the timer is called
+func (t *Timer) Remaining() time.Duration {
+	sched.mu.Lock()
+	defer sched.mu.Unlock()
+
+	if t.paused {
+		return t.remaining
+	}
+	return time.Until(t.deadline)
+}
+
+func schedule(name string, scope TimerScope, owner *Mode, d time.Duration, repeat time.Duration, fn func()) *Timer {
+	sched.mu.Lock()
+	defer sched.mu.Unlock()
+
+	if t, ok := sched.timers[name]; ok {
+		t.stop()
+	}
+
+	t := &Timer{
+		name:   name,
+		scope:  scope,
+		owner:  owner,
+		fn:     fn,
+		repeat: repeat,
+	}
+	sched.timers[name] = t
+
+	if sched.paused && scope != ScopeMachine {
+		t.paused = true
+		t.remaining = d
+		return t
+	}
+
+	t.start(d)
+	return t
+}
+
+// the following need to be called with the scheduler lock held
+
+func (t *Timer) start(d time.Duration) {
+	t.deadline = time.Now().Add(d)
+	t.timer = time.AfterFunc(d, t.fire)
+}
+
+func (t *Timer) stop() {
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+	delete(sched.timers, t.name)
+}
+
+func (t *Timer) pause() {
+	if t.scope == ScopeMachine || t.paused {
+		return
+	}
+
+	t.paused = true
+	t.timer.Stop()
+	t.remaining = time.Until(t.deadline)
+	if t.remaining < 0 {
+		t.remaining = 0
+	}
+}
+
+func (t *Timer) resume() {
+	if !t.paused {
+		return
+	}
+
+	t.paused = false
+	t.start(t.remaining)
+}
+
+func (t *Timer) fire() {
+	sched.mu.Lock()
+	if sched.timers[t.name] != t || t.paused {
+		//cancelled or paused after the timer had already gone off
+		sched.mu.Unlock()
+		return
+	}
+
+	if t.repeat > 0 {
+		t.start(t.repeat)
+	} else {
+		delete(sched.timers, t.name)
+	}
+	sched.mu.Unlock()
+
+	t.fn()
+}
+
+// cancelModeTimers cancels all of the timers started by the mode
+func cancelModeTimers(m *Mode) {
+	sched.mu.Lock()
+	defer sched.mu.Unlock()
+
+	for _, t := range sched.timers {
+		if t.owner == m {
+			t.stop()
+		}
+	}
+}
+
+// schedulerStateChange is called on every state transition to pause, resume and cancel timers
+func schedulerStateChange(to MachineState) {
+	switch to {
+	case StateBallEnding:
+		CancelScope(ScopeBall)
+		PauseTimers()
+	case StateBonus, StateService:
+		PauseTimers()
+	case StateBallStarting, StateBallInPlay, StateAttract:
+		ResumeTimers()
+	case StateGameOver:
+		CancelScope(ScopeBall)
+		CancelScope(ScopePlayer)
+		CancelScope(ScopeGame)
+		ResumeTimers()
+	}
+}
@@ -0,0 +1,203 @@
+package goflip
+
+import (
+	"encoding/json"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+scoring adds points to the current player's score. Points are multiplied by the
+playfield multiplier (reset at every PlayerUp) and by the ScoreMultiplier of every
+active Mode. Points added with AddSwitchScore are also totalled per switch for the
+game. Every score change is sent to the ScoreObservers and the web interface.
+
+Points are ignored unless a ball is in play (not tilted, not game over). When a score
+goes past what the displays can show, ScoreConfig.Rollover decides what happens.
+*/
+
+// RolloverRule is what happens when a score goes past ScoreConfig.DisplayMax
+type RolloverRule int
+
+const (
+	RolloverWrap RolloverRule = iota //score keeps counting, the display shows the lowest digits
+	RolloverCap                      //score stops at DisplayMax
+)
+
+const defaultDisplayMax = 9999999 //7 digit displays
+
+// ScoreConfig holds the settings for the scoring engine
+type ScoreConfig struct {
+	Rollover   RolloverRule
+	DisplayMax int64 //highest score the player displays can show
+}
+
+// ScoreEvent describes a change to a player's score
+type ScoreEvent struct {
+	Player     int
+	BasePoints int   //points before any multipliers
+	Multiplier int   //combined playfield and mode multiplier
+	Points     int64 //points actually added
+	Score      int64 //player's score after the points were added
+	SwitchID   int   //switch the points are for, NoSwitch if none
+	Rollover   bool  //true if this change rolled the score past DisplayMax
+}
+
+// ScoreObserver can optionally be implemented by an Observer to be told of every score change
+type ScoreObserver interface {
+	ScoreChanged(e ScoreEvent)
+}
+
+type scoringState struct {
+	mu                  sync.Mutex
+	playfieldMultiplier int
+	switchScores        map[int]int64
+}
+
+var scoring = scoringState{playfieldMultiplier: 1, switchScores: make(map[int]int64)}
+
+// AddScore adds points (with multipliers) to the current player's score
+func AddScore(points int) {
+	addScore(points, NoSwitch, true)
+}
+
+// AddSwitchScore adds points (with multipliers) to the current player's score, and totals them for the switch
+func AddSwitchScore(switchID int, points int) {
+	addScore(points, switchID, true)
+}
+
+// SetPlayfieldMultiplier sets the playfield multiplier for the rest of the ball
+func SetPlayfieldMultiplier(multiplier int) {
+	if multiplier < 1 {
+		multiplier = 1
+	}
+
+	scoring.mu.Lock()
+	defer scoring.mu.Unlock()
+	scoring.playfieldMultiplier = multiplier
+}
+
+// PlayfieldMultiplier returns the playfield multiplier for the ball in play
+func PlayfieldMultiplier() int {
+	scoring.mu.Lock()
+	defer scoring.mu.Unlock()
+	return scoring.playfieldMultiplier
+}
+
+// ScoreMultiplier returns the combined playfield and active mode multiplier
+func ScoreMultiplier() int {
+	multiplier := PlayfieldMultiplier()
+	for _, m := range ActiveModes() {
+		if m.ScoreMultiplier > 1 {
+			multiplier *= m.ScoreMultiplier
+		}
+	}
+	return multiplier
+}
+
+// SwitchScores returns the points scored by each switch this game
+func SwitchScores() map[int]int64 {
+	scoring.mu.Lock()
+	defer scoring.mu.Unlock()
+
+	scores := make(map[int]int64, len(scoring.switchScores))
+	for sw, points := range scoring.switchScores {
+		scores[sw] = points
+	}
+	return scores
+}
+
+// scoringAllowed returns true if points can be added in the current state
+func scoringAllowed() bool {
+	g := GetMachine()
+	if g.Tilted {
+		return false
+	}
+
+	switch GetState() {
+	case StateBallStarting, StateBallInPlay, StateBallEnding, StateBonus:
+		return true
+	}
+	return false
+}
+
+func addScore(points int, switchID int, multiply bool) {
+	g := GetMachine()
+	if g.CurrentPlayer < 1 || g.CurrentPlayer > len(g.scores) {
+		return
+	}
+
+	if !scoringAllowed() {
+		log.Debugf("scoring: ignoring %d points in state %v", points, GetState())
+		return
+	}
+
+	e := ScoreEvent{
+		Player:     g.CurrentPlayer,
+		BasePoints: points,
+		Multiplier: 1,
+		SwitchID:   switchID,
+	}
+	if multiply {
+		e.Multiplier = ScoreMultiplier()
+	}
+	e.Points = int64(points) * int64(e.Multiplier)
+
+	before := g.scores[g.CurrentPlayer-1]
+	after := before + e.Points
+
+	max := g.ScoreConfig.DisplayMax
+	if max > 0 {
+		if g.ScoreConfig.Rollover == RolloverCap && after > max && e.Points > 0 {
+			after = max
+			if after < before {
+				//already over the cap (DisplayMax lowered during the game), the score is left where it is
+				after = before
+			}
+			e.Points = after - before
+		}
+		e.Rollover = before/(max+1) != after/(max+1)
+	}
+
+	g.scores[g.CurrentPlayer-1] = after
+	g.BallScore += e.Points
+	e.Score = after
+	log.Debugf("goFlip:BallScore = %d, total = %d\n", g.BallScore, after)
+
+	if switchID != NoSwitch {
+		scoring.mu.Lock()
+		scoring.switchScores[switchID] += e.Points
+		scoring.mu.Unlock()
+	}
+
+	//refresh display
+	setPlayerDisplay(g.CurrentPlayer, after)
+
+	for _, f := range g.Observers {
+		if o, ok := f.(ScoreObserver); ok {
+			o.ScoreChanged(e)
+		}
+	}
+	sendScoreEvent(e)
+
+	checkReplay()
+}
+
+// resetScoring is called at GameStart
+func resetScoring() {
+	scoring.mu.Lock()
+	defer scoring.mu.Unlock()
+
+	scoring.playfieldMultiplier = 1
+	scoring.switchScores = make(map[int]int64)
+}
+
+func sendScoreEvent(e ScoreEvent) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		log.Errorln("Error in marshalling:", err)
+		return
+	}
+	Broadcast("score", string(b))
+}
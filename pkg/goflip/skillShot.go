@@ -0,0 +1,173 @@
+package goflip
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+skillShot awards the player for hitting the lit target within a window of time after
+the ball is launched. The lit target can be moved with the RotateSwitches (the
+flipper buttons typically) while the ball is still in the shooter lane.
+*/
+
+// SkillShotConfig holds the settings for the skill shot
+type SkillShotConfig struct {
+	Enabled        bool
+	Targets        []int         //switch IDs that can be the skill shot
+	Lamps          []int         //lamp for each of the Targets (same order). Can be left empty
+	Window         time.Duration //time after the launch that the target must be hit in
+	Points         int
+	Sound          byte  //sound played when the skill shot is made. 0 for none
+	RotateSwitches []int //switches that move the lit target before the launch
+}
+
+// SkillShotObserver can optionally be implemented by an Observer to be told when the skill shot is made
+type SkillShotObserver interface {
+	SkillShotAwarded(switchID int, points int)
+}
+
+type skillShotState struct {
+	mu     sync.Mutex
+	target int  //index into SkillShotConfig.Targets
+	armed  bool //true from PlayerUp until the skill shot is made or missed
+	open   bool //true while the window after launch is open
+}
+
+const skillShotTimer = "skillShot"
+
+var skillShot skillShotState
+
+// SetSkillShotTarget selects which of the SkillShotConfig.Targets is lit for the skill shot
+func SetSkillShotTarget(index int) {
+	g := GetMachine()
+	if index < 0 || index >= len(g.SkillShotConfig.Targets) {
+		log.Warnf("skillShot: invalid target index %d", index)
+		return
+	}
+
+	skillShot.mu.Lock()
+	skillShot.target = index
+	armed := skillShot.armed
+	skillShot.mu.Unlock()
+
+	if armed {
+		showSkillShot()
+	}
+}
+
+// armSkillShot is called at PlayerUp to get the skill shot ready for the next launch
+func armSkillShot() {
+	g := GetMachine()
+	if !g.SkillShotConfig.Enabled || len(g.SkillShotConfig.Targets) == 0 {
+		return
+	}
+
+	skillShot.mu.Lock()
+	skillShot.armed = true
+	skillShot.open = false
+	skillShot.mu.Unlock()
+
+	showSkillShot()
+}
+
+// startSkillShot opens the window for the skill shot when the ball is launched
+func startSkillShot() {
+	g := GetMachine()
+
+	skillShot.mu.Lock()
+	defer skillShot.mu.Unlock()
+
+	if !skillShot.armed {
+		return
+	}
+
+	skillShot.open = true
+	After(skillShotTimer, ScopeBall, g.SkillShotConfig.Window, endSkillShot)
+}
+
+// endSkillShot closes the skill shot, turning off the lamps
+func endSkillShot() {
+	g := GetMachine()
+
+	skillShot.mu.Lock()
+	if !skillShot.armed {
+		skillShot.mu.Unlock()
+		return
+	}
+
+	skillShot.armed = false
+	skillShot.open = false
+	skillShot.mu.Unlock()
+
+	CancelTimer(skillShotTimer)
+
+	LampOff(g.SkillShotConfig.Lamps...)
+}
+
+func showSkillShot() {
+	g := GetMachine()
+
+	skillShot.mu.Lock()
+	target := skillShot.target
+	skillShot.mu.Unlock()
+
+	for i, l := range g.SkillShotConfig.Lamps {
+		if i == target {
+			LampSlowBlink(l)
+		} else {
+			LampOff(l)
+		}
+	}
+}
+
+// skillShotSwitch is called for every switch event while a game is in progress
+func skillShotSwitch(sw SwitchEvent) {
+	g := GetMachine()
+	cfg := g.SkillShotConfig
+
+	if !sw.Pressed {
+		return
+	}
+
+	skillShot.mu.Lock()
+	armed, open, target := skillShot.armed, skillShot.open, skillShot.target
+	skillShot.mu.Unlock()
+
+	if !armed {
+		return
+	}
+
+	if !open {
+		//ball is still in the shooter lane
+		if containsInt(cfg.RotateSwitches, sw.SwitchID) && len(cfg.Targets) > 0 {
+			SetSkillShotTarget((target + 1) % len(cfg.Targets))
+		}
+		return
+	}
+
+	if !containsInt(cfg.Targets, sw.SwitchID) {
+		return
+	}
+
+	endSkillShot()
+
+	if cfg.Targets[target] != sw.SwitchID {
+		log.Debugf("skillShot: missed, hit %d", sw.SwitchID)
+		return
+	}
+
+	log.Infof("skillShot: awarded for switch %d", sw.SwitchID)
+	AddScore(cfg.Points)
+	if cfg.Sound > 0 {
+		PlaySound(cfg.Sound)
+	}
+
+	for _, f := range g.Observers {
+		if o, ok := f.(SkillShotObserver); ok {
+			o.SkillShotAwarded(sw.SwitchID, cfg.Points)
+		}
+	}
+}
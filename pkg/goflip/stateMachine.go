@@ -0,0 +1,270 @@
+package goflip
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+stateMachine is the single source of truth for where the machine is in a game.
+Every state change goes through ChangeState, which checks it against the declared
+legal transitions. Transitions are processed one at a time and in order: if a
+transition is requested while the hooks of another are still running (from a hook
+itself, or from another go routine), it is validated right away but its hooks are
+run after the current ones finish.
+
+GState and PState (GetGameState/GetPlayerState) are still kept up to date from the
+machine state for games that use them.
+
+	Attract -> GameStarted -> BallStarting -> BallInPlay -> BallEnding -> Bonus -> BallStarting ...
+	                                                                            \-> GameOver -> Attract
+*/
+
+// MachineState is the state of the machine
+type MachineState int
+
+const (
+	StateAttract      MachineState = iota //no game in progress
+	StateGameStarted                      //a game was just started, no ball up yet
+	StateBallStarting                     //a player is up, ball has not been launched yet
+	StateBallInPlay                       //ball was launched and is on the playfield
+	StateBallEnding                       //ball drained
+	StateBonus                            //end of ball bonus is being counted
+	StateGameOver                         //last ball of the game is over
+	StateService                          //operator test mode
+	StateTilted                           //ball in play is tilted
+
+	AnyState MachineState = -1 //used with AddTransitionHook to match any state
+)
+
+var stateNames = map[MachineState]string{
+	StateAttract:      "Attract",
+	StateGameStarted:  "GameStarted",
+	StateBallStarting: "BallStarting",
+	StateBallInPlay:   "BallInPlay",
+	StateBallEnding:   "BallEnding",
+	StateBonus:        "Bonus",
+	StateGameOver:     "GameOver",
+	StateService:      "Service",
+	StateTilted:       "Tilted",
+	AnyState:          "Any",
+}
+
+func (s MachineState) String() string {
+	if name, ok := stateNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("MachineState(%d)", int(s))
+}
+
+// legalTransitions lists every state that can be moved to from each state
+var legalTransitions = map[MachineState][]MachineState{
+	StateAttract:      {StateGameStarted, StateService},
+	StateService:      {StateAttract},
+	StateGameStarted:  {StateBallStarting, StateGameOver},
+	StateBallStarting: {StateBallInPlay, StateBallEnding, StateTilted, StateGameOver},
+	StateBallInPlay:   {StateBallEnding, StateTilted, StateGameOver},
+	StateTilted:       {StateBallEnding, StateGameOver},
+	StateBallEnding:   {StateBonus, StateBallStarting, StateGameOver},
+	StateBonus:        {StateBallStarting, StateGameOver},
+	StateGameOver:     {StateAttract},
+}
+
+// ErrIllegalTransition is returned (wrapped) by ChangeState when the transition is not allowed
+var ErrIllegalTransition = errors.New("illegal state transition")
+
+// TransitionHook is called after the machine moves from one state to another
+type TransitionHook func(from, to MachineState)
+
+type transitionHook struct {
+	from MachineState
+	to   MachineState
+	hook TransitionHook
+}
+
+type stateTransition struct {
+	from MachineState
+	to   MachineState
+}
+
+type stateMachine struct {
+	mu         sync.Mutex
+	current    MachineState
+	queue      []stateTransition
+	processing bool
+	hooks      []transitionHook
+}
+
+var machineState stateMachine
+
+// GetState returns the current state of the machine
+func GetState() MachineState {
+	machineState.mu.Lock()
+	defer machineState.mu.Unlock()
+	return machineState.current
+}
+
+// IsLegalTransition returns true if the machine is allowed to move from one state to the other
+func IsLegalTransition(from, to MachineState) bool {
+	for _, s := range legalTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// AddTransitionHook adds a hook that is called on the transition from one state to the other.
+// Either state can be AnyState. Hooks are called after goflip's own handling of the transition,
+// in the order they were added.
+func AddTransitionHook(from, to MachineState, hook TransitionHook) {
+	machineState.mu.Lock()
+	defer machineState.mu.Unlock()
+
+	machineState.hooks = append(machineState.hooks, transitionHook{from: from, to: to, hook: hook})
+}
+
+// ChangeState moves the machine to a new state. An error wrapping ErrIllegalTransition is
+// returned if the move is not allowed from the current state, or if a game is started while
+// a player is entering their high score initials.
+func ChangeState(to MachineState) error {
+	sm := &machineState
+
+	if to == StateGameStarted && IsEnteringInitials() {
+		return fmt.Errorf("%w: %v while entering high score initials", ErrIllegalTransition, to)
+	}
+
+	sm.mu.Lock()
+	from := sm.current
+	if !IsLegalTransition(from, to) {
+		sm.mu.Unlock()
+		return fmt.Errorf("%w: %v to %v", ErrIllegalTransition, from, to)
+	}
+
+	log.Debugf("stateMachine: %v -> %v", from, to)
+	sm.current = to
+	setLegacyStates(to)
+	sm.queue = append(sm.queue, stateTransition{from: from, to: to})
+
+	if sm.processing {
+		//the go routine processing the queue will get to it
+		sm.mu.Unlock()
+		return nil
+	}
+
+	sm.processing = true
+	for len(sm.queue) > 0 {
+		t := sm.queue[0]
+		sm.queue = sm.queue[1:]
+
+		var hooks []TransitionHook
+		for _, h := range sm.hooks {
+			if (h.from == AnyState || h.from == t.from) && (h.to == AnyState || h.to == t.to) {
+				hooks = append(hooks, h.hook)
+			}
+		}
+		sm.mu.Unlock()
+
+		enterState(t.from, t.to)
+		for _, h := range hooks {
+			h(t.from, t.to)
+		}
+
+		sm.mu.Lock()
+	}
+	sm.processing = false
+	sm.mu.Unlock()
+
+	return nil
+}
+
+// setLegacyStates keeps GState and PState in line with the machine state. Called with the lock held
+func setLegacyStates(to MachineState) {
+	g := GetMachine()
+
+	switch to {
+	case StateAttract, StateService:
+		if g.gameState != GameEnded {
+			g.gameState = Init
+		}
+		g.playerState = NoPlayer
+	case StateGameStarted:
+		g.gameState = InProgress
+		g.playerState = NoPlayer
+	case StateBallStarting, StateBallInPlay, StateTilted:
+		g.gameState = InProgress
+		g.playerState = UpPlayer
+	case StateBallEnding, StateBonus:
+		g.gameState = InProgress
+		g.playerState = EndPlayer
+	case StateGameOver:
+		g.gameState = GameEnded
+		g.playerState = NoPlayer
+	}
+}
+
+// enterState is goflip's own handling of each transition, calling the game events for the Observers
+func enterState(from, to MachineState) {
+	g := GetMachine()
+
+	schedulerStateChange(to)
+	giStateChange(to)
+
+	if from == StateService {
+		g.TestMode = false
+		ClearLampLayer(LayerService)
+	}
+	if from == StateAttract {
+		StopAttract()
+	}
+
+	switch to {
+	case StateService:
+		g.TestMode = true
+	case StateGameStarted:
+		GameStart()
+		startModes(ModeStartGame)
+	case StateBallStarting:
+		PlayerUp()
+		if GetState() == StateBallStarting {
+			//PlayerUp didn't end the game
+			startModes(ModeStartBall)
+		}
+	case StateTilted:
+		g.Tilted = true
+	case StateBallEnding:
+		stopModes(true)
+		CurrentPlayerVars().SaveLamps(g.PlayerLamps...)
+		changeState(StateBonus)
+	case StateBonus:
+		game := g.gameNumber
+		go func() {
+			countBonus()
+			PlayerEnd()
+
+			//the game could have been aborted (or restarted) while the bonus was counted
+			if g.gameNumber == game && GetState() == StateBonus {
+				changeState(StateBallStarting)
+			}
+		}()
+	case StateGameOver:
+		stopModes(false)
+		GameOver()
+		changeState(StateAttract)
+	case StateAttract:
+		StartAttract()
+	}
+
+	autofireStateChange(to)
+	flipperStateChange(to)
+}
+
+// changeState is used for goflip's own transitions, where an illegal transition is logged rather than returned
+func changeState(to MachineState) {
+	if err := ChangeState(to); err != nil {
+		log.Warnf("stateMachine: %v", err)
+	}
+}
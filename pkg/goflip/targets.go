@@ -0,0 +1,400 @@
+package goflip
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+targets are reusable devices for the target banks on the playfield.
+
+DropTargetBank tracks which drop targets are down, and fires the reset coil to bring
+them back up. After the reset the switches are checked, and the coil is fired again
+(up to MaxRetries) if any target is still down.
+
+TargetGroup is a group of standup targets or rollover lanes, each with a lamp that is
+lit when it is made. The lit lamps can be rotated with the flipper buttons (lane change).
+
+Both are registered at Init, and are reset at GameStart. Switches are ignored while
+the ball is tilted.
+*/
+
+const defaultVerifyDelay = 250 * time.Millisecond
+
+// TargetBankObserver can optionally be implemented by an Observer to be told when a bank or group is completed
+type TargetBankObserver interface {
+	TargetBankCompleted(name string)
+}
+
+// DropTargetBank is a bank of drop targets with a reset coil
+type DropTargetBank struct {
+	Name            string
+	Switches        []int         //switch for each target, pressed when the target is down
+	ResetCoil       int           //NoCoil for a bank that is reset by hand (or by a mechanism goflip doesn't drive)
+	VerifyDelay     time.Duration //time after firing the reset coil before the switches are checked. 0 for the default (250ms)
+	MaxRetries      int           //number of times the reset coil is fired again if a target didn't come up
+	ResetOnComplete bool          //reset the bank as soon as all of the targets are down
+
+	OnTargetDown  func(b *DropTargetBank, index int)
+	OnComplete    func(b *DropTargetBank)
+	OnResetFailed func(b *DropTargetBank)
+
+	mu        sync.Mutex
+	down      []bool
+	resetting bool
+}
+
+// TargetGroup is a group of standup targets or rollover lanes
+type TargetGroup struct {
+	Name                string
+	Switches            []int
+	Lamps               []int //lamp for each target, lit when the target is made
+	RotateLeftSwitches  []int //rotates the lit lamps left (left flipper button)
+	RotateRightSwitches []int //rotates the lit lamps right (right flipper button)
+	ResetOnComplete     bool  //turn all of the lamps back off when the group is complete
+
+	OnTargetHit func(t *TargetGroup, index int, newlyLit bool)
+	OnComplete  func(t *TargetGroup)
+
+	mu  sync.Mutex
+	lit []bool
+}
+
+var (
+	dropTargetBanks []*DropTargetBank
+	targetGroups    []*TargetGroup
+	targetsMu       sync.Mutex
+)
+
+// NewDropTargetBank returns a drop target bank reset by resetCoil, NoCoil if it doesn't have one
+func NewDropTargetBank(name string, switches []int, resetCoil int) *DropTargetBank {
+	return &DropTargetBank{Name: name, Switches: switches, ResetCoil: resetCoil}
+}
+
+// RegisterDropTargetBank adds a drop target bank so that it receives switch events. The bank needs
+// at least one switch
+func RegisterDropTargetBank(b *DropTargetBank) error {
+	if len(b.Switches) == 0 {
+		return fmt.Errorf("drop target bank %s has no switches", b.Name)
+	}
+	if b.VerifyDelay <= 0 {
+		//the switches have to be read after the targets have come back up
+		b.VerifyDelay = defaultVerifyDelay
+	}
+
+	b.down = make([]bool, len(b.Switches))
+
+	targetsMu.Lock()
+	defer targetsMu.Unlock()
+	dropTargetBanks = append(dropTargetBanks, b)
+	return nil
+}
+
+// RegisterTargetGroup adds a standup or rollover group so that it receives switch events
+func RegisterTargetGroup(t *TargetGroup) {
+	t.lit = make([]bool, len(t.Switches))
+
+	targetsMu.Lock()
+	defer targetsMu.Unlock()
+	targetGroups = append(targetGroups, t)
+}
+
+// IsDown returns true if the target at index is down
+func (b *DropTargetBank) IsDown(index int) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return index >= 0 && index < len(b.down) && b.down[index]
+}
+
+// DownCount returns the number of targets that are down
+func (b *DropTargetBank) DownCount() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	count := 0
+	for _, d := range b.down {
+		if d {
+			count++
+		}
+	}
+	return count
+}
+
+// IsComplete returns true if all of the targets are down
+func (b *DropTargetBank) IsComplete() bool {
+	return b.DownCount() == len(b.Switches)
+}
+
+// Reset fires the reset coil and checks that all of the targets came back up. A bank with
+// no reset coil just goes by what its switches say
+func (b *DropTargetBank) Reset() {
+	if b.ResetCoil == NoCoil {
+		b.readSwitches()
+		return
+	}
+
+	b.mu.Lock()
+	if b.resetting {
+		b.mu.Unlock()
+		return
+	}
+	b.resetting = true
+	b.mu.Unlock()
+
+	go func() {
+		for try := 0; try <= b.MaxRetries; try++ {
+			SolenoidFire(b.ResetCoil)
+			time.Sleep(b.VerifyDelay)
+
+			up := true
+			for _, sw := range b.Switches {
+				if SwitchPressed(sw) {
+					up = false
+				}
+			}
+
+			if up {
+				b.mu.Lock()
+				for i := range b.down {
+					b.down[i] = false
+				}
+				b.resetting = false
+				b.mu.Unlock()
+				return
+			}
+
+			log.Warnf("targets: %s did not reset, try %d", b.Name, try+1)
+		}
+
+		log.Errorf("targets: %s failed to reset", b.Name)
+
+		b.readSwitches()
+		if b.OnResetFailed != nil {
+			b.OnResetFailed(b)
+		}
+	}()
+}
+
+// readSwitches sets which targets are down from the switches, and ends the reset
+func (b *DropTargetBank) readSwitches() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for i, sw := range b.Switches {
+		b.down[i] = SwitchPressed(sw)
+	}
+	b.resetting = false
+}
+
+func (b *DropTargetBank) switchHandler(sw SwitchEvent) {
+	if !sw.Pressed {
+		return
+	}
+
+	b.mu.Lock()
+	index := -1
+	for i, s := range b.Switches {
+		if s == sw.SwitchID {
+			index = i
+		}
+	}
+
+	if index < 0 || b.resetting || b.down[index] {
+		b.mu.Unlock()
+		return
+	}
+
+	b.down[index] = true
+	b.mu.Unlock()
+
+	if b.OnTargetDown != nil {
+		b.OnTargetDown(b, index)
+	}
+
+	if b.IsComplete() {
+		log.Debugf("targets: %s complete", b.Name)
+		if b.OnComplete != nil {
+			b.OnComplete(b)
+		}
+		notifyTargetBankCompleted(b.Name)
+
+		if b.ResetOnComplete {
+			b.Reset()
+		}
+	}
+}
+
+// IsLit returns true if the target at index has been made
+func (t *TargetGroup) IsLit(index int) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return index >= 0 && index < len(t.lit) && t.lit[index]
+}
+
+// LitCount returns the number of targets that have been made
+func (t *TargetGroup) LitCount() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	count := 0
+	for _, l := range t.lit {
+		if l {
+			count++
+		}
+	}
+	return count
+}
+
+// IsComplete returns true if all of the targets have been made
+func (t *TargetGroup) IsComplete() bool {
+	return t.LitCount() == len(t.Switches)
+}
+
+// Reset turns off all of the targets in the group
+func (t *TargetGroup) Reset() {
+	t.mu.Lock()
+	for i := range t.lit {
+		t.lit[i] = false
+	}
+	t.mu.Unlock()
+
+	t.showLamps()
+}
+
+// Rotate moves the lit targets one position. Positive is to the right, negative to the left
+func (t *TargetGroup) Rotate(direction int) {
+	t.mu.Lock()
+	n := len(t.lit)
+	if n < 2 {
+		t.mu.Unlock()
+		return
+	}
+
+	rotated := make([]bool, n)
+	for i, l := range t.lit {
+		if direction > 0 {
+			rotated[(i+1)%n] = l
+		} else {
+			rotated[(i+n-1)%n] = l
+		}
+	}
+	t.lit = rotated
+	t.mu.Unlock()
+
+	t.showLamps()
+}
+
+func (t *TargetGroup) showLamps() {
+	t.mu.Lock()
+	lit := append([]bool(nil), t.lit...)
+	t.mu.Unlock()
+
+	for i, l := range t.Lamps {
+		if i >= len(lit) {
+			break
+		}
+		if lit[i] {
+			LampOn(l)
+		} else {
+			LampOff(l)
+		}
+	}
+}
+
+func (t *TargetGroup) switchHandler(sw SwitchEvent) {
+	if !sw.Pressed {
+		return
+	}
+
+	if containsInt(t.RotateLeftSwitches, sw.SwitchID) {
+		t.Rotate(-1)
+		return
+	}
+
+	if containsInt(t.RotateRightSwitches, sw.SwitchID) {
+		t.Rotate(1)
+		return
+	}
+
+	t.mu.Lock()
+	index := -1
+	for i, s := range t.Switches {
+		if s == sw.SwitchID {
+			index = i
+		}
+	}
+
+	if index < 0 {
+		t.mu.Unlock()
+		return
+	}
+
+	newlyLit := !t.lit[index]
+	t.lit[index] = true
+	t.mu.Unlock()
+
+	t.showLamps()
+
+	if t.OnTargetHit != nil {
+		t.OnTargetHit(t, index, newlyLit)
+	}
+
+	if newlyLit && t.IsComplete() {
+		log.Debugf("targets: %s complete", t.Name)
+		if t.OnComplete != nil {
+			t.OnComplete(t)
+		}
+		notifyTargetBankCompleted(t.Name)
+
+		if t.ResetOnComplete {
+			t.Reset()
+		}
+	}
+}
+
+func notifyTargetBankCompleted(name string) {
+	g := GetMachine()
+	for _, f := range g.Observers {
+		if o, ok := f.(TargetBankObserver); ok {
+			o.TargetBankCompleted(name)
+		}
+	}
+}
+
+// targetSwitchHandler passes switch events to all of the registered target devices
+func targetSwitchHandler(sw SwitchEvent) {
+	g := GetMachine()
+	if g.Tilted {
+		return
+	}
+
+	targetsMu.Lock()
+	banks := append([]*DropTargetBank(nil), dropTargetBanks...)
+	groups := append([]*TargetGroup(nil), targetGroups...)
+	targetsMu.Unlock()
+
+	for _, b := range banks {
+		b.switchHandler(sw)
+	}
+	for _, t := range groups {
+		t.switchHandler(sw)
+	}
+}
+
+// resetTargets is called at GameStart to put all of the target devices back to the start
+func resetTargets() {
+	targetsMu.Lock()
+	banks := append([]*DropTargetBank(nil), dropTargetBanks...)
+	groups := append([]*TargetGroup(nil), targetGroups...)
+	targetsMu.Unlock()
+
+	for _, b := range banks {
+		b.Reset()
+	}
+	for _, t := range groups {
+		t.Reset()
+	}
+}
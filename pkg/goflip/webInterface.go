@@ -17,17 +17,14 @@ var (
 
 //GameStats tells the stats of the game in play (what you would see from the backbox mostly)
 type GameStats struct {
-	Player1Score int32
-	Player2Score int32
-	Player3Score int32
-	Player4Score int32
+	Scores       []int64 //score for each player in the game, player 1 first
 	Match        int16
 	TotalBalls   int
 	BallInPlay   int
-	Display1     int32
-	Display2     int32
-	Display3     int32
-	Display4     int32
+	Display1     int64
+	Display2     int64
+	Display3     int64
+	Display4     int64
 	Credits      int16
 }
 
@@ -89,6 +86,26 @@ func StartServer() {
 	ws := gotalk.WebSocketHandler()
 	ws.OnAccept = onAccept
 
+	gotalk.Handle("abortGame", func() error {
+		return AbortGame()
+	})
+
+	gotalk.Handle("highScores", func() (map[string]HighScoreTable, error) {
+		return AllHighScores(), nil
+	})
+
+	gotalk.Handle("resetHighScores", func() error {
+		return ResetHighScores()
+	})
+
+	gotalk.Handle("lamps", func() ([]LampInfo, error) {
+		return Lamps(), nil
+	})
+
+	gotalk.Handle("flippers", func() ([]FlipperStats, error) {
+		return Flippers(), nil
+	})
+
 	folder := `/goflip/web`
 	http.Handle("/socket/", ws)
 
@@ -115,6 +132,19 @@ func StartServer() {
 		w.Write(js)
 	})
 
+	http.HandleFunc("/highscores", func(w http.ResponseWriter, r *http.Request) {
+		js, err := json.Marshal(AllHighScores())
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		w.Write(js)
+	})
+
 	var port = ":8080"
 
 	log.Debugf("Server listening - http://%s%s", "127.0.0.1", port)
@@ -8,15 +8,23 @@ $scope.connectWS = function(){
         $scope.messages = new Array;
 		});
     });
+    $scope.sock = s;
+};
+
+$scope.abortGame = function(){
+    $scope.sock.request('abortGame', null, function(err){
+        if (err) {
+            $scope.$apply(function () {
+                $scope.messages.push({Message: 'abortGame: ' + err});
+            });
+        }
+    });
 };
 
 gotalk.handleNotification('stat', function(scores){
     var js = JSON.parse(scores);
 	$scope.$apply(function () {
-    $scope.Player1Score = js.Player1Score ;
-	$scope.Player2Score = js.Player2Score ;
-	$scope.Player3Score = js.Player3Score ;
-	$scope.Player4Score = js.Player4Score ;
+    $scope.Scores       = js.Scores       ;
 	$scope.Match        = js.Match        ;
 	$scope.TotalBalls   = js.TotalBalls   ;
 	$scope.BallInPlay   = js.BallInPlay   ;
@@ -29,6 +37,58 @@ gotalk.handleNotification('stat', function(scores){
 });
 
 
+$scope.loadHighScores = function(){
+    $scope.sock.request('highScores', null, function(err, tables){
+        $scope.$apply(function () {
+            $scope.highScores = tables;
+        });
+    });
+};
+
+$scope.loadLamps = function(){
+    $scope.sock.request('lamps', null, function(err, lamps){
+        $scope.$apply(function () {
+            $scope.lamps = lamps;
+        });
+    });
+};
+
+$scope.loadFlippers = function(){
+    $scope.sock.request('flippers', null, function(err, flippers){
+        $scope.$apply(function () {
+            $scope.flippers = flippers;
+        });
+    });
+};
+
+gotalk.handleNotification('initials', function(entry){
+    var js = JSON.parse(entry);
+	$scope.$apply(function () {
+	$scope.Initials = js;
+	});
+});
+
+gotalk.handleNotification('score', function(scoreEvent){
+    var js = JSON.parse(scoreEvent);
+	$scope.$apply(function () {
+	if (!$scope.Scores) {
+		$scope.Scores = new Array;
+	}
+	$scope.Scores[js.Player - 1] = js.Score;
+	$scope.LastScore = js;
+	});
+});
+
+gotalk.handleNotification('coilAlert', function(alert){
+    var js = JSON.parse(alert);
+	$scope.$apply(function () {
+	if (!$scope.CoilAlerts) {
+		$scope.CoilAlerts = new Array;
+	}
+	$scope.CoilAlerts.push(js);
+	});
+});
+
 gotalk.handleNotification('msg', function(logEvent){
     var js = JSON.parse(logEvent);
 $scope.$apply(function () {